@@ -20,6 +20,7 @@ import (
 type Client struct {
 	baseURL    string
 	apiKey     string
+	batchSize  int // Keys per lookup request
 	httpClient *http.Client
 	limiter    *rate.Limiter
 }
@@ -34,8 +35,9 @@ func (c *Client) Close() error {
 
 func NewClient(cfg *config.AppConfig) *Client {
 	return &Client{
-		baseURL: cfg.API.BaseURL,
-		apiKey:  cfg.API.APIKey,
+		baseURL:   cfg.API.BaseURL,
+		apiKey:    cfg.API.APIKey.Value(),
+		batchSize: cfg.API.BatchSize,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 			Transport: &http.Transport{
@@ -104,7 +106,7 @@ func (c *Client) InsertCustomers(customers []models.Customer) (map[string]int, e
 			return nil, fmt.Errorf("error decoding response: %v, body: %s", err, string(body))
 		}
 
-		customerIDs[customer.CustomerNumber] = result.ID
+		customerIDs[customer.Key()] = result.ID
 
 		if (i+1)%100 == 0 {
 			log.Printf("Processed %d/%d customers", i+1, len(customers))
@@ -164,7 +166,7 @@ func (c *Client) InsertAccounts(accounts []models.Account) (map[string]int, erro
 			return nil, fmt.Errorf("error decoding response: %v, body: %s", err, string(body))
 		}
 
-		accountIDs[account.AccountNumber] = result.ID
+		accountIDs[account.Key()] = result.ID
 
 		if (i+1)%100 == 0 {
 			log.Printf("Processed %d/%d accounts", i+1, len(accounts))
@@ -174,37 +176,38 @@ func (c *Client) InsertAccounts(accounts []models.Account) (map[string]int, erro
 	return accountIDs, nil
 }
 
-func (c *Client) InsertCustomerAccounts(links []models.CustomerAccount, customerIDs, accountIDs map[string]int) error {
+func (c *Client) InsertCustomerAccounts(links []models.CustomerAccount, customerIDs, accountIDs map[string]int) (int, error) {
+	written := 0
 	for i, link := range links {
 		err := c.limiter.Wait(context.Background())
 		if err != nil {
-			return fmt.Errorf("rate limiter error: %v", err)
+			return written, fmt.Errorf("rate limiter error: %v", err)
 		}
 
-		customerID, ok := customerIDs[link.CustomerNumber]
+		customerID, ok := customerIDs[link.CustomerKey()]
 		if !ok {
-			log.Printf("Warning: Customer %s not found, skipping link", link.CustomerNumber)
+			log.Printf("Warning: Customer %s not found, skipping link", link.CustomerKey())
 			continue
 		}
 
-		accountID, ok := accountIDs[link.AccountNumber]
+		accountID, ok := accountIDs[link.AccountKey()]
 		if !ok {
-			log.Printf("Warning: Account %s not found, skipping link", link.AccountNumber)
+			log.Printf("Warning: Account %s not found, skipping link", link.AccountKey())
 			continue
 		}
 
-		requestBody := models.ToLinkRequest(customerID, accountID)
+		requestBody := models.ToLinkRequest(link, customerID, accountID)
 
 		payload, err := json.Marshal(requestBody)
 		if err != nil {
-			return fmt.Errorf("error marshaling link: %v", err)
+			return written, fmt.Errorf("error marshaling link: %v", err)
 		}
 
 		log.Printf("Sending link payload: %s", string(payload))
 
 		req, err := http.NewRequest("POST", fmt.Sprintf("%s/customer-accounts", c.baseURL), bytes.NewBuffer(payload))
 		if err != nil {
-			return fmt.Errorf("error creating request: %v", err)
+			return written, fmt.Errorf("error creating request: %v", err)
 		}
 
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
@@ -212,24 +215,26 @@ func (c *Client) InsertCustomerAccounts(links []models.CustomerAccount, customer
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			return fmt.Errorf("error making request: %v", err)
+			return written, fmt.Errorf("error making request: %v", err)
 		}
 		defer resp.Body.Close()
 
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return fmt.Errorf("error reading response body: %v", err)
+			return written, fmt.Errorf("error reading response body: %v", err)
 		}
 
 		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-			return fmt.Errorf("API returned status %d for link %s-%s: %s",
+			return written, fmt.Errorf("API returned status %d for link %s-%s: %s",
 				resp.StatusCode, link.CustomerNumber, link.AccountNumber, string(body))
 		}
 
+		written++
+
 		if (i+1)%100 == 0 {
 			log.Printf("Processed %d/%d links", i+1, len(links))
 		}
 	}
 
-	return nil
+	return written, nil
 }
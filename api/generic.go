@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"importer/entity"
+)
+
+var _ entity.Repository = (*Client)(nil)
+
+// PrepareEntities has nothing to check for the API
+func (c *Client) PrepareEntities(def *entity.Definition) error {
+	return nil
+}
+
+// UpsertRecords posts every record of a defined entity to its endpoint as a
+// JSON object of its fields. Reference fields are sent as the referenced ID
+// under the reference column, and empty optional fields are left out.
+func (c *Client) UpsertRecords(e *entity.Entity, records []entity.Record, refs map[string]map[string]int) (map[string]int, error) {
+	ids := make(map[string]int)
+
+	for i, r := range records {
+		err := c.limiter.Wait(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("rate limiter error: %v", err)
+		}
+
+		requestBody := make(map[string]interface{}, len(e.Fields))
+		for _, f := range e.Fields {
+			value := r.Values[f.Name]
+			if ref, ok := e.Reference(f.Name); ok {
+				if value != "" {
+					requestBody[ref.Column] = refs[ref.Entity][value]
+				}
+				continue
+			}
+			if value != "" || f.Required {
+				requestBody[f.Name] = value
+			}
+		}
+
+		payload, err := json.Marshal(requestBody)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling %s: %v", e.Name, err)
+		}
+
+		log.Printf("Sending %s payload: %s", e.Name, string(payload))
+
+		req, err := http.NewRequest("POST", c.baseURL+e.Endpoint, bytes.NewBuffer(payload))
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %v", err)
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error making request: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading response body: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			return nil, fmt.Errorf("API returned status %d for %s %s: %s",
+				resp.StatusCode, e.Name, e.KeyOf(r), string(body))
+		}
+
+		// Endpoints that create nothing referenced later may not return an ID
+		var result struct {
+			ID int `json:"id"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("error decoding response: %v, body: %s", err, string(body))
+		}
+		ids[e.KeyOf(r)] = result.ID
+
+		if (i+1)%100 == 0 {
+			log.Printf("Processed %d/%d %s rows", i+1, len(records), e.Name)
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"importer/models"
+)
+
+var _ models.IDResolver = (*Client)(nil)
+
+// ResolveCustomerIDs looks up existing customers with
+// GET /customers?customer_number=...&customer_number=...
+func (c *Client) ResolveCustomerIDs(keys []models.NaturalKey) (map[string]int, error) {
+	ids, err := c.resolve("/customers", "customer_number", keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up customers: %v", err)
+	}
+	return ids, nil
+}
+
+// ResolveAccountIDs looks up existing accounts with
+// GET /accounts?account_number=...&account_number=...
+func (c *Client) ResolveAccountIDs(keys []models.NaturalKey) (map[string]int, error) {
+	ids, err := c.resolve("/accounts", "account_number", keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up accounts: %v", err)
+	}
+	return ids, nil
+}
+
+// resolve requests the numbers of each tenant in batches. The API answers
+// with the matching records, numbers it does not know are left out.
+func (c *Client) resolve(path, param string, keys []models.NaturalKey) (map[string]int, error) {
+	byTenant := make(map[string][]string)
+	var tenants []string
+	for _, k := range keys {
+		if _, ok := byTenant[k.Tenant]; !ok {
+			tenants = append(tenants, k.Tenant)
+		}
+		byTenant[k.Tenant] = append(byTenant[k.Tenant], k.Number)
+	}
+
+	batchSize := c.batchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	ids := make(map[string]int)
+	for _, tenant := range tenants {
+		numbers := byTenant[tenant]
+		for start := 0; start < len(numbers); start += batchSize {
+			query := url.Values{}
+			for _, n := range numbers[start:min(start+batchSize, len(numbers))] {
+				query.Add(param, n)
+			}
+			if tenant != "" {
+				query.Set("client_id", tenant)
+			}
+
+			var records []map[string]interface{}
+			if err := c.get(path+"?"+query.Encode(), &records); err != nil {
+				return nil, err
+			}
+			for _, r := range records {
+				number, _ := r[param].(string)
+				id, _ := r["id"].(float64)
+				if number != "" && id != 0 {
+					ids[models.ScopedKey(tenant, number)] = int(id)
+				}
+			}
+		}
+	}
+	return ids, nil
+}
+
+// get requests path and decodes the JSON response into v
+func (c *Client) get(path string, v interface{}) error {
+	if err := c.limiter.Wait(context.Background()); err != nil {
+		return fmt.Errorf("rate limiter error: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", c.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned status %d for GET %s: %s", resp.StatusCode, path, string(body))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error decoding response: %v, body: %s", err, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,684 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"importer/config"
+	"importer/db"
+	"importer/diff"
+	"importer/entity"
+	"importer/excel"
+	"importer/generator"
+	"importer/match"
+	"importer/mockapi"
+	"importer/models"
+	"importer/transform"
+)
+
+func runGenerate(args []string) error {
+	fs := newFlagSet("generate", "")
+	numRows := fs.Int("rows", 100000, "Number of customers to generate")
+	outputFile := fs.String("file", "test_data.xlsx", "Excel file to write")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	gen := generator.NewGenerator(generator.GeneratorConfig{
+		NumCustomers:    *numRows,
+		MultiAcctChance: 0.3,
+		ThirdAcctChance: 0.1,
+		CustomerPrefix:  "CUST",
+		AccountPrefix:   "ACC",
+	})
+
+	start := time.Now()
+	if err := excel.GenerateFile(*outputFile, gen); err != nil {
+		return err
+	}
+	log.Printf("Total generation time: %v", time.Since(start))
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := newFlagSet("import", "[file.xlsx]")
+	inputFile := fs.String("file", "test_data.xlsx", "Excel file to process")
+	baselineFile := fs.String("baseline", "", "Only import rows that are new or modified compared to this workbook or manifest (.json)")
+	manifestFile := fs.String("manifest", "", "Write the manifest of the imported rows to this file after a successful run (default <workbook>.manifest.json)")
+	noManifest := fs.Bool("no-manifest", false, "Do not write a manifest")
+	operator := fs.String("operator", defaultOperator(), "Operator recorded in the import run ledger")
+	client := fs.String("client", "", "Tenant mode: only import rows of this client ID and reject the rest")
+	orphans := fs.String("orphans", excel.OrphansSkip, "Links whose customer or account is not found: skip them, or abort the run")
+	checkTarget := fs.Bool("check-target", true, "Look for customers and accounts of links that are not in the workbook in the target")
+	definitions := fs.String("definitions", "", "Import the entities described in this definition file, see 'importer definitions'")
+	only := fs.String("only", "", "Only import these sheets, comma-separated: customers, accounts, links")
+	cfgFlags := addConfigFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := fileArg(fs, inputFile); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(cfgFlags)
+	if err != nil {
+		return err
+	}
+	if *orphans != excel.OrphansSkip && *orphans != excel.OrphansAbort {
+		return usageError{msg: fmt.Sprintf("-orphans must be skip or abort, got %q", *orphans)}
+	}
+	if *client != "" && !cfg.TenantMode {
+		return usageError{msg: "-client requires tenant mode, set tenant_mode or TENANT_MODE=true"}
+	}
+	sheets, err := parseOnly(*only)
+	if err != nil {
+		return err
+	}
+	var def *entity.Definition
+	if *definitions != "" {
+		// The generic path has none of these; refuse rather than ignore them
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if *baselineFile != "" || *manifestFile != "" || *only != "" || set["orphans"] || set["check-target"] || cfg.TenantMode {
+			return usageError{msg: "-definitions cannot be combined with -baseline, -manifest, -only, -orphans, -check-target or tenant mode"}
+		}
+		if hasTransforms(cfg.Transforms) || hasAttributes(cfg.Attributes) {
+			return usageError{msg: "-definitions cannot be combined with configured transforms or attributes"}
+		}
+		def, err = entity.Load(*definitions)
+		if err != nil {
+			return err
+		}
+	}
+	if *noManifest && *manifestFile != "" {
+		return usageError{msg: "-manifest cannot be combined with -no-manifest"}
+	}
+	if *only != "" && *manifestFile != "" {
+		return usageError{msg: "-only cannot be combined with -manifest, the manifest would lack the other sheets"}
+	}
+	if *manifestFile == "" && !*noManifest && def == nil && *only == "" {
+		*manifestFile = defaultManifest(*inputFile)
+	}
+
+	transforms, err := transform.New(cfg.Transforms)
+	if err != nil {
+		return fmt.Errorf("invalid transforms: %v", err)
+	}
+
+	dataStore, err := openRepository(cfg)
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	importer := excel.NewImporter(dataStore, cfg)
+	importer.SetTransforms(transforms)
+	importer.SetDuplicates(cfg.Duplicates)
+	importer.SetOrphanPolicy(*orphans)
+	importer.SetCheckTarget(*checkTarget)
+	importer.SetOnly(sheets)
+	importer.SetTenantMode(cfg.TenantMode)
+	importer.SetClient(*client)
+	importer.SetAttributes(cfg.Attributes)
+	if *baselineFile != "" {
+		importer.SetBaseline(*baselineFile)
+	}
+	if *manifestFile != "" {
+		importer.SetManifest(*manifestFile)
+	}
+	if def != nil {
+		importer.SetDefinition(def)
+	}
+	importer.SetOperator(*operator)
+	return importer.Import(*inputFile)
+}
+
+func runDefinitions(args []string) error {
+	fs := newFlagSet("definitions", "[definitions.yaml]")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch fs.NArg() {
+	case 0:
+		_, err := os.Stdout.Write(entity.DefaultYAML())
+		return err
+	case 1:
+	default:
+		return usageError{msg: fmt.Sprintf("definitions takes a single file, got %d", fs.NArg())}
+	}
+
+	def, err := entity.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	order, err := def.Order()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENTITY\tSHEET\tTABLE\tENDPOINT\tKEY\tREFERENCES")
+	for _, e := range order {
+		var refs []string
+		for _, r := range e.References {
+			refs = append(refs, r.Field+" -> "+r.Entity)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Name, e.Sheet, e.Table, e.Endpoint,
+			strings.Join(e.Key, ", "), strings.Join(refs, ", "))
+	}
+	return w.Flush()
+}
+
+// defaultOperator is $IMPORTER_OPERATOR, falling back to the login name
+func defaultOperator() string {
+	if op := os.Getenv("IMPORTER_OPERATOR"); op != "" {
+		return op
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+func runRuns(args []string) error {
+	fs := newFlagSet("runs", "")
+	limit := fs.Int("limit", 20, "Number of runs to list")
+	cfgFlags := addConfigFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(cfgFlags)
+	if err != nil {
+		return err
+	}
+
+	dataStore, err := openRepository(cfg)
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	ledger, ok := dataStore.(models.RunLedger)
+	if !ok {
+		return fmt.Errorf("the configured backend does not keep an import run ledger")
+	}
+
+	runs, err := ledger.ListRuns(*limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN ID\tSTARTED\tDURATION\tSTATUS\tOPERATOR\tFILE\tCUSTOMERS\tACCOUNTS\tLINKS\tENTITIES\tERROR")
+	for _, run := range runs {
+		duration := "-"
+		if run.FinishedAt != nil {
+			duration = run.FinishedAt.Sub(run.StartedAt).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n",
+			run.RunID, run.StartedAt.Format(time.RFC3339), duration, run.Status, run.Operator,
+			run.FileName, run.CustomerCount, run.AccountCount, run.LinkCount,
+			entityCounts(run.EntityCounts), firstLine(run.ErrorSummary))
+	}
+	return w.Flush()
+}
+
+// entityCounts formats the rows written per entity as name=count, sorted by
+// name, or "-" for runs of the built-in import
+func entityCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "-"
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%d", name, counts[name])
+	}
+	return strings.Join(parts, " ")
+}
+
+func runRollback(args []string) error {
+	fs := newFlagSet("rollback", "run-id")
+	force := fs.Bool("force", false, "Roll back even if later runs changed the same rows")
+	cfgFlags := addConfigFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{msg: "rollback requires a run ID, see 'importer runs'"}
+	}
+	runID := fs.Arg(0)
+
+	cfg, err := loadConfig(cfgFlags)
+	if err != nil {
+		return err
+	}
+	if cfg.API.UseAPI {
+		return usageError{msg: "rollback only applies to the postgres backend, use --backend=postgres"}
+	}
+
+	dataStore, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	result, err := dataStore.RollbackRun(runID, *force)
+	if err != nil {
+		return err
+	}
+	log.Printf("Rolled back run %s: %d rows deleted, %d rows restored", runID, result.Deleted, result.Restored)
+	if result.Missing > 0 {
+		log.Printf("%d rows were already gone", result.Missing)
+	}
+	return nil
+}
+
+func runHistory(args []string) error {
+	fs := newFlagSet("history", "customer-number")
+	account := fs.Bool("account", false, "Show the history of an account number instead")
+	client := fs.String("client", "", "Client ID the number belongs to, required in tenant mode")
+	cfgFlags := addConfigFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{msg: "history requires a customer number, or an account number with -account"}
+	}
+	key := fs.Arg(0)
+
+	cfg, err := loadConfig(cfgFlags)
+	if err != nil {
+		return err
+	}
+	if cfg.API.UseAPI {
+		return usageError{msg: "history only applies to the postgres backend, use --backend=postgres"}
+	}
+	if *client != "" && !cfg.TenantMode {
+		return usageError{msg: "-client requires tenant mode, set tenant_mode or TENANT_MODE=true"}
+	}
+	if *client == "" && cfg.TenantMode {
+		return usageError{msg: "tenant mode is enabled, pass -client"}
+	}
+
+	dataStore, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	var changes []db.FieldChange
+	if *account {
+		changes, err = dataStore.AccountHistory(*client, key)
+	} else {
+		changes, err = dataStore.CustomerHistory(*client, key)
+	}
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		log.Printf("No recorded changes for %s", key)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGED\tRUN ID\tFIELD\tOLD\tNEW")
+	for _, c := range changes {
+		runID := c.RunID
+		if runID == "" {
+			runID = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			c.ChangedAt.Format(time.RFC3339), runID, c.Field, c.OldValue, c.NewValue)
+	}
+	return w.Flush()
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func runVerify(args []string) error {
+	fs := newFlagSet("verify", "[file.xlsx]")
+	inputFile := fs.String("file", "test_data.xlsx", "Excel file to validate")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := fileArg(fs, inputFile); err != nil {
+		return err
+	}
+
+	wb, err := excel.ReadWorkbook(*inputFile)
+	if err != nil {
+		return err
+	}
+
+	if errs := wb.Validate(); errs != nil {
+		excel.LogValidationErrors(errs)
+		return errs
+	}
+	log.Printf("%s is valid", *inputFile)
+	return nil
+}
+
+func runExport(args []string) error {
+	fs := newFlagSet("export", "")
+	outputFile := fs.String("file", "export.xlsx", "Excel file to write")
+	cfgFlags := addConfigFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(cfgFlags)
+	if err != nil {
+		return err
+	}
+
+	dataStore, err := openRepository(cfg)
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	exporter, ok := dataStore.(models.Exporter)
+	if !ok {
+		return fmt.Errorf("the configured backend does not support export")
+	}
+
+	wb, err := exporter.ExportWorkbook()
+	if err != nil {
+		return err
+	}
+	if err := excel.WriteWorkbook(*outputFile, wb); err != nil {
+		return err
+	}
+	log.Printf("Exported %d customers, %d accounts and %d links to %s",
+		len(wb.Customers), len(wb.Accounts), len(wb.Links), *outputFile)
+	return nil
+}
+
+func runDiff(args []string) error {
+	fs := newFlagSet("diff", "old.xlsx new.xlsx")
+	reportName := fs.String("report", "diff_report", "Base name of the diff report (.xlsx and .json are written)")
+	configFile := fs.String("config", "", "YAML config file (default $IMPORTER_CONFIG)")
+	profile := fs.String("profile", "", "Profile of the config file to apply (default $IMPORTER_PROFILE)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usageError{msg: "diff requires two files: importer diff old.xlsx new.xlsx"}
+	}
+	oldFile, newFile := fs.Arg(0), fs.Arg(1)
+
+	// Only tenant mode is used, no backend is opened
+	cfg, err := config.LoadConfig(config.Options{File: *configFile, Profile: *profile})
+	if err != nil {
+		return err
+	}
+
+	oldWB, err := excel.ReadWorkbook(oldFile)
+	if err != nil {
+		return err
+	}
+	newWB, err := excel.ReadWorkbook(newFile)
+	if err != nil {
+		return err
+	}
+	if cfg.TenantMode {
+		// Key rows by client ID, so a number used by two clients is two rows
+		errs := append(excel.AssignTenants(oldWB), excel.AssignTenants(newWB)...)
+		if len(errs) > 0 {
+			excel.LogValidationErrors(errs)
+			return errs
+		}
+	}
+
+	report := diff.Compare(oldWB, newWB)
+	report.OldFile = oldFile
+	report.NewFile = newFile
+
+	if err := report.WriteWorkbook(*reportName + ".xlsx"); err != nil {
+		return err
+	}
+	if err := report.WriteJSON(*reportName + ".json"); err != nil {
+		return err
+	}
+
+	log.Printf("Customers: %d added, %d removed, %d changed",
+		len(report.Customers.Added), len(report.Customers.Removed), len(report.Customers.Changed))
+	log.Printf("Accounts:  %d added, %d removed, %d changed",
+		len(report.Accounts.Added), len(report.Accounts.Removed), len(report.Accounts.Changed))
+	log.Printf("Links:     %d added, %d removed",
+		len(report.Links.Added), len(report.Links.Removed))
+	log.Printf("Diff report written to %s.xlsx and %s.json", *reportName, *reportName)
+	return nil
+}
+
+func runMatch(args []string) error {
+	fs := newFlagSet("match", "incoming.xlsx")
+	against := fs.String("against", "", "Workbook of the existing customers (default: export them from the configured backend)")
+	threshold := fs.Float64("threshold", 0.85, "Minimum score of a reported candidate, from 0 to 1")
+	reportName := fs.String("report", "match_report", "Base name of the candidate report (.xlsx and .json are written)")
+	cfgFlags := addConfigFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{msg: "match requires the incoming workbook: importer match incoming.xlsx"}
+	}
+	if *threshold < 0 || *threshold > 1 {
+		return usageError{msg: fmt.Sprintf("-threshold must be between 0 and 1, got %v", *threshold)}
+	}
+	incomingFile := fs.Arg(0)
+
+	incoming, err := excel.ReadWorkbook(incomingFile)
+	if err != nil {
+		return err
+	}
+
+	var existing *models.Workbook
+	source := *against
+	if *against != "" {
+		existing, err = excel.ReadWorkbook(*against)
+		if err != nil {
+			return err
+		}
+	} else {
+		cfg, err := loadConfig(cfgFlags)
+		if err != nil {
+			return err
+		}
+		dataStore, err := openRepository(cfg)
+		if err != nil {
+			return err
+		}
+		defer dataStore.Close()
+
+		exporter, ok := dataStore.(models.Exporter)
+		if !ok {
+			return fmt.Errorf("the configured backend does not support export, use -against")
+		}
+		existing, err = exporter.ExportWorkbook()
+		if err != nil {
+			return err
+		}
+		source = "backend"
+	}
+
+	log.Printf("Matching %d incoming customers against %d existing customers...",
+		len(incoming.Customers), len(existing.Customers))
+	report := match.Find(incoming.Customers, existing.Customers, *threshold)
+	report.IncomingFile = incomingFile
+	report.Existing = source
+
+	if err := report.WriteWorkbook(*reportName + ".xlsx"); err != nil {
+		return err
+	}
+	if err := report.WriteJSON(*reportName + ".json"); err != nil {
+		return err
+	}
+	log.Printf("Compared %d pairs, found %d candidate duplicates scoring at least %v",
+		report.Compared, len(report.Candidates), *threshold)
+	log.Printf("Match report written to %s.xlsx and %s.json", *reportName, *reportName)
+	return nil
+}
+
+func runMigrate(args []string) error {
+	fs := newFlagSet("migrate", "up|down|status")
+	target := fs.Int("to", 0, "up: stop at this version (default latest)")
+	steps := fs.Int("steps", 1, "down: number of migrations to revert")
+	cfgFlags := addConfigFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{msg: "migrate requires one of: up, down, status"}
+	}
+	action := fs.Arg(0)
+	if action != "up" && action != "down" && action != "status" {
+		return usageError{msg: fmt.Sprintf("unknown migrate action %q, expected up, down or status", action)}
+	}
+
+	cfg, err := loadConfig(cfgFlags)
+	if err != nil {
+		return err
+	}
+	if cfg.API.UseAPI {
+		return usageError{msg: "migrate only applies to the postgres backend, use --backend=postgres"}
+	}
+
+	migrator, err := db.NewMigrator(cfg)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch action {
+	case "up":
+		return migrator.Up(*target)
+	case "down":
+		return migrator.Down(*steps)
+	}
+
+	status, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, applied)
+	}
+	return nil
+}
+
+func runMockAPI(args []string) error {
+	fs := newFlagSet("mockapi", "")
+	port := fs.Int("port", 3000, "Port to run mock API on")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	return mockapi.ListenAndServe(fmt.Sprintf(":%d", *port))
+}
+
+func runSecrets(args []string) error {
+	fs := newFlagSet("secrets", "")
+	inputFile := fs.String("in", "", "Plain JSON object of secret names to values, e.g. {\"DB_PASSWORD\": \"...\"}")
+	outputFile := fs.String("out", "secrets.enc", "Encrypted secrets file to write")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *inputFile == "" {
+		return usageError{msg: "secrets requires -in"}
+	}
+
+	key, err := config.SecretsKey()
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return usageError{msg: "set SECRETS_KEY or SECRETS_KEY_FILE to the encryption passphrase"}
+	}
+
+	data, err := os.ReadFile(*inputFile)
+	if err != nil {
+		return err
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return fmt.Errorf("%s is not a JSON object of strings: %v", *inputFile, err)
+	}
+
+	encrypted, err := config.EncryptSecrets(secrets, key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*outputFile, encrypted, 0600); err != nil {
+		return err
+	}
+	log.Printf("Encrypted %d secrets to %s", len(secrets), *outputFile)
+	return nil
+}
+
+// defaultManifest is where the manifest of an import is written unless
+// -manifest is given: next to the workbook, as <name>.manifest.json
+func defaultManifest(workbook string) string {
+	return strings.TrimSuffix(workbook, filepath.Ext(workbook)) + ".manifest.json"
+}
+
+// parseOnly splits the -only flag into sheet names, nil if it is empty
+func hasTransforms(t config.TransformsConfig) bool {
+	return len(t.Customers)+len(t.Accounts)+len(t.Links) > 0
+}
+
+func hasAttributes(a config.AttributesConfig) bool {
+	return len(a.Customers)+len(a.Accounts)+len(a.Links) > 0
+}
+
+func parseOnly(only string) ([]string, error) {
+	if only == "" {
+		return nil, nil
+	}
+	var sheets []string
+	for _, sheet := range strings.Split(only, ",") {
+		sheet = strings.ToLower(strings.TrimSpace(sheet))
+		switch sheet {
+		case excel.OnlyCustomers, excel.OnlyAccounts, excel.OnlyLinks:
+			sheets = append(sheets, sheet)
+		default:
+			return nil, usageError{msg: fmt.Sprintf("-only takes customers, accounts and links, got %q", sheet)}
+		}
+	}
+	return sheets, nil
+}
+
+// fileArg lets the input file be given as a positional argument instead of -file
+func fileArg(fs *flag.FlagSet, file *string) error {
+	switch fs.NArg() {
+	case 0:
+		return nil
+	case 1:
+		*file = fs.Arg(0)
+		return nil
+	default:
+		return usageError{msg: fmt.Sprintf("%s takes a single file, got %d", fs.Name(), fs.NArg())}
+	}
+}
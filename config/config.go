@@ -1,87 +1,509 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type DatabaseConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
+	URL             string `yaml:"url"` // postgres:// DSN, replaces host/port/user/password/dbname when set
+	Host            string `yaml:"host"`
+	Port            int    `yaml:"port"`
+	User            string `yaml:"user"`
+	Password        Secret `yaml:"password"`
+	DBName          string `yaml:"dbname"`
+	SSLMode         string `yaml:"sslmode"` // disable, require, verify-ca or verify-full
+	SSLRootCert     string `yaml:"sslrootcert"`
+	SSLCert         string `yaml:"sslcert"`
+	SSLKey          string `yaml:"sslkey"`
+	SearchPath      string `yaml:"search_path"`
+	ApplicationName string `yaml:"application_name"` // Default "importer", unless the DSN sets one
+	ConnectTimeout  int    `yaml:"connect_timeout"`  // Seconds, 0 waits indefinitely
+
+	// Connection pool
+	MaxOpenConns    int           `yaml:"max_open_conns"` // 0 means unlimited
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"` // 0 means connections are reused forever
+	LinkWorkers     int           `yaml:"link_workers"`      // Concurrent workers for the links phase
+
+	// Startup retries for databases that are still booting
+	ConnectRetries int           `yaml:"connect_retries"`
+	ConnectBackoff time.Duration `yaml:"connect_backoff"` // Initial delay, doubled after each attempt
+
+	// Target table and column names, see SchemaMapping
+	Mapping SchemaMapping `yaml:"mapping"`
 }
 
 type APIConfig struct {
-	BaseURL   string
-	APIKey    string
-	RateLimit int  // Requests per second
-	BatchSize int  // Number of records per request
-	UseAPI    bool // Whether to use API instead of direct DB
+	BaseURL   string `yaml:"base_url"`
+	APIKey    Secret `yaml:"api_key"`
+	RateLimit int    `yaml:"rate_limit"` // Requests per second
+	BatchSize int    `yaml:"batch_size"` // Number of records per request
+	UseAPI    bool   `yaml:"use_api"`    // Whether to use API instead of direct DB
 }
 
 type AppConfig struct {
-	DB        DatabaseConfig
-	API       APIConfig
-	BatchSize int
+	DB         DatabaseConfig `yaml:"db"`
+	API        APIConfig      `yaml:"api"`
+	BatchSize  int            `yaml:"batch_size"`
+	TenantMode bool           `yaml:"tenant_mode"` // Scope customer and account numbers by client ID
+	Duplicates string         `yaml:"duplicates"`  // Policy for rows repeating a key: first, last, merge or reject
+
+	// Extra sheet columns kept as attributes
+	Attributes AttributesConfig `yaml:"attributes"`
+
+	// Cleanup rules applied to fields between reading and validation
+	Transforms TransformsConfig `yaml:"transforms"`
+}
+
+// AttributesConfig lists, per sheet, the headers of extra columns that are
+// kept as attributes. Headers match case-insensitively and "*" keeps every
+// extra column; columns not listed are dropped.
+type AttributesConfig struct {
+	Customers []string `yaml:"customers"`
+	Accounts  []string `yaml:"accounts"`
+	Links     []string `yaml:"links"`
 }
 
-func LoadConfig() (*AppConfig, error) {
+// Policies for rows of a workbook that repeat the natural key of an earlier
+// row of the same sheet
+const (
+	DuplicatesFirst  = "first"  // Keep the first row
+	DuplicatesLast   = "last"   // Keep the last row
+	DuplicatesMerge  = "merge"  // Keep the first row, updated with the non-empty fields of later ones
+	DuplicatesReject = "reject" // Reject every row with the key
+)
+
+// TransformsConfig lists, per sheet, the transformation rules applied to the
+// fields of every row after it is read. Rules run in order, so a rule sees
+// the fields transformed before it.
+type TransformsConfig struct {
+	Customers []FieldTransform `yaml:"customers"`
+	Accounts  []FieldTransform `yaml:"accounts"`
+	Links     []FieldTransform `yaml:"links"`
+}
+
+// FieldTransform is the pipeline of steps applied to one field
+type FieldTransform struct {
+	Field string          `yaml:"field"` // e.g. customer_number, email, or an attribute header
+	Steps []TransformStep `yaml:"steps"`
+}
+
+// TransformStep is one step of a field's pipeline. Op selects the step and
+// the other fields are its options.
+type TransformStep struct {
+	Op      string `yaml:"op"`      // trim, case, replace, default, lookup, template, email or address
+	Case    string `yaml:"case"`    // case: upper, lower or title
+	Pattern string `yaml:"pattern"` // replace: regular expression
+	With    string `yaml:"with"`    // replace: replacement, may refer to groups as $1
+	Value   string `yaml:"value"`   // default: for blank values; lookup: for unmapped values; template: text/template over the row
+
+	// lookup: the translations, given inline, as a CSV file or as a sheet of
+	// the imported workbook, each with a header row and code and translation
+	// in the first two columns
+	Table    map[string]string `yaml:"table"`
+	File     string            `yaml:"file"`
+	Sheet    string            `yaml:"sheet"`
+	Unmapped string            `yaml:"unmapped"` // pass (default), default or reject
+
+	// email and address: fields receiving the parsed components, e.g.
+	// {flags: Email Flags} or {street: Street, city: City, postcode: Postcode}
+	Into map[string]string `yaml:"into"`
+	// email: reject rows whose address is disposable and/or a role address
+	Reject []string `yaml:"reject"`
+}
+
+// Options selects the config file and profile layered under the environment
+type Options struct {
+	File    string // Config file, IMPORTER_CONFIG when empty
+	Profile string // Profile within the file, IMPORTER_PROFILE when empty
+
+	// SecretProviders are consulted, in order, for DB_PASSWORD, API_KEY and
+	// DATABASE_URL before the environment. The encrypted file named by
+	// SECRETS_FILE is appended automatically.
+	SecretProviders []SecretProvider
+}
+
+// LoadConfig builds the configuration from, in increasing precedence, the
+// built-in defaults, the defaults section of the config file, the selected
+// profile, secret providers and the environment (including a .env file and
+// *_FILE variants of secrets). Malformed values are reported rather than
+// replaced by defaults; call Validate before use.
+func LoadConfig(opts Options) (*AppConfig, error) {
 	// Load .env file if it exists
 	godotenv.Load()
 
+	cfg := defaultConfig()
+
+	if opts.File == "" {
+		opts.File = os.Getenv("IMPORTER_CONFIG")
+	}
+	if opts.Profile == "" {
+		opts.Profile = os.Getenv("IMPORTER_PROFILE")
+	}
+	if opts.File != "" {
+		if err := loadFile(cfg, opts.File, opts.Profile); err != nil {
+			return nil, err
+		}
+	} else if opts.Profile != "" {
+		return nil, fmt.Errorf("profile %q selected without a config file", opts.Profile)
+	}
+
+	providers, err := defaultSecretProviders()
+	if err != nil {
+		return nil, err
+	}
+	if err := loadSecrets(cfg, append(opts.SecretProviders, providers...)); err != nil {
+		return nil, err
+	}
+
+	if err := loadEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	registerSecret(cfg.DB.Password.Value())
+	registerSecret(cfg.API.APIKey.Value())
+	registerURLPassword(cfg.DB.URL)
+	return cfg, nil
+}
+
+// registerURLPassword registers the password of a connection URL both
+// decoded and percent-encoded, as written in the URL or escaped again by
+// net/url in error messages
+func registerURLPassword(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		return
+	}
+	registerSecret(password)
+	registerSecret(url.QueryEscape(password))
+	registerSecret(url.PathEscape(password))
+	if _, escaped, ok := strings.Cut(url.UserPassword("", password).String(), ":"); ok {
+		registerSecret(escaped)
+	}
+
+	// The password exactly as written, which may escape other characters
+	if _, rest, ok := strings.Cut(rawURL, "://"); ok {
+		authority, _, _ := strings.Cut(rest, "/")
+		if i := strings.LastIndex(authority, "@"); i >= 0 {
+			if _, written, ok := strings.Cut(authority[:i], ":"); ok {
+				registerSecret(written)
+			}
+		}
+	}
+}
+
+func defaultConfig() *AppConfig {
 	return &AppConfig{
 		DB: DatabaseConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnvAsInt("DB_PORT", 5432),
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", "your-password"),
-			DBName:   getEnv("DB_NAME", "your-database"),
+			Host:            "localhost",
+			Port:            5432,
+			User:            "postgres",
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			LinkWorkers:     4,
+			ConnectRetries:  5,
+			ConnectBackoff:  time.Second,
 		},
 		API: APIConfig{
-			BaseURL:   getEnv("API_BASE_URL", ""),
-			APIKey:    getEnv("API_KEY", ""),
-			RateLimit: getEnvAsInt("API_RATE_LIMIT", 60),
-			BatchSize: getEnvAsInt("API_BATCH_SIZE", 100),
-			UseAPI:    getEnvAsBool("USE_API", false),
+			RateLimit: 60,
+			BatchSize: 100,
 		},
-		BatchSize: getEnvAsInt("BATCH_SIZE", 1000),
-	}, nil
+		BatchSize:  1000,
+		Duplicates: DuplicatesLast,
+	}
+}
+
+func loadEnv(cfg *AppConfig) error {
+	var errs []error
+	errs = append(errs, setSecretFromEnv("DATABASE_URL", &cfg.DB.URL))
+	setFromEnv("DB_HOST", &cfg.DB.Host)
+	errs = append(errs, setIntFromEnv("DB_PORT", &cfg.DB.Port))
+	setFromEnv("DB_USER", &cfg.DB.User)
+	password := cfg.DB.Password.Value()
+	errs = append(errs, setSecretFromEnv("DB_PASSWORD", &password))
+	cfg.DB.Password = Secret(password)
+	setFromEnv("DB_NAME", &cfg.DB.DBName)
+	setFromEnv("DB_SSLMODE", &cfg.DB.SSLMode)
+	setFromEnv("DB_SSLROOTCERT", &cfg.DB.SSLRootCert)
+	setFromEnv("DB_SSLCERT", &cfg.DB.SSLCert)
+	setFromEnv("DB_SSLKEY", &cfg.DB.SSLKey)
+	setFromEnv("DB_SEARCH_PATH", &cfg.DB.SearchPath)
+	setFromEnv("DB_APPLICATION_NAME", &cfg.DB.ApplicationName)
+	errs = append(errs, setIntFromEnv("DB_CONNECT_TIMEOUT", &cfg.DB.ConnectTimeout))
+	errs = append(errs, setIntFromEnv("DB_MAX_OPEN_CONNS", &cfg.DB.MaxOpenConns))
+	errs = append(errs, setIntFromEnv("DB_MAX_IDLE_CONNS", &cfg.DB.MaxIdleConns))
+	errs = append(errs, setDurationFromEnv("DB_CONN_MAX_LIFETIME", &cfg.DB.ConnMaxLifetime))
+	errs = append(errs, setIntFromEnv("DB_LINK_WORKERS", &cfg.DB.LinkWorkers))
+	errs = append(errs, setIntFromEnv("DB_CONNECT_RETRIES", &cfg.DB.ConnectRetries))
+	errs = append(errs, setDurationFromEnv("DB_CONNECT_BACKOFF", &cfg.DB.ConnectBackoff))
+
+	setFromEnv("API_BASE_URL", &cfg.API.BaseURL)
+	apiKey := cfg.API.APIKey.Value()
+	errs = append(errs, setSecretFromEnv("API_KEY", &apiKey))
+	cfg.API.APIKey = Secret(apiKey)
+	errs = append(errs, setIntFromEnv("API_RATE_LIMIT", &cfg.API.RateLimit))
+	errs = append(errs, setIntFromEnv("API_BATCH_SIZE", &cfg.API.BatchSize))
+	errs = append(errs, setBoolFromEnv("USE_API", &cfg.API.UseAPI))
+
+	errs = append(errs, setIntFromEnv("BATCH_SIZE", &cfg.BatchSize))
+	errs = append(errs, setBoolFromEnv("TENANT_MODE", &cfg.TenantMode))
+	setFromEnv("DUPLICATES", &cfg.Duplicates)
+	setListFromEnv("CUSTOMER_ATTRIBUTES", &cfg.Attributes.Customers)
+	setListFromEnv("ACCOUNT_ATTRIBUTES", &cfg.Attributes.Accounts)
+	setListFromEnv("LINK_ATTRIBUTES", &cfg.Attributes.Links)
+	return errors.Join(errs...)
+}
+
+// Validate rejects configurations the importer cannot run with
+func (c *AppConfig) Validate() error {
+	var errs []error
+	if c.BatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("BATCH_SIZE must be positive, got %d", c.BatchSize))
+	}
+	switch c.Duplicates {
+	case DuplicatesFirst, DuplicatesLast, DuplicatesMerge, DuplicatesReject:
+	default:
+		errs = append(errs, fmt.Errorf("DUPLICATES must be first, last, merge or reject, got %q", c.Duplicates))
+	}
+
+	if c.API.UseAPI {
+		if c.API.BaseURL == "" {
+			errs = append(errs, errors.New("API_BASE_URL is required when USE_API is true"))
+		} else if u, err := url.Parse(c.API.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("API_BASE_URL %q is not an http(s) URL", c.API.BaseURL))
+		}
+		if c.API.RateLimit <= 0 {
+			errs = append(errs, fmt.Errorf("API_RATE_LIMIT must be positive, got %d", c.API.RateLimit))
+		}
+		if c.API.BatchSize <= 0 {
+			errs = append(errs, fmt.Errorf("API_BATCH_SIZE must be positive, got %d", c.API.BatchSize))
+		}
+	} else {
+		errs = append(errs, c.DB.validate()...)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	return nil
 }
 
+func (c *DatabaseConfig) validate() []error {
+	var errs []error
+	if c.URL != "" {
+		if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			errs = append(errs, errors.New("DATABASE_URL is not a postgres:// URL")) // Never echo the URL, it may hold a password
+		}
+	} else {
+		if c.Host == "" {
+			errs = append(errs, errors.New("DB_HOST is required"))
+		}
+		if c.Port <= 0 || c.Port > 65535 {
+			errs = append(errs, fmt.Errorf("DB_PORT must be between 1 and 65535, got %d", c.Port))
+		}
+		if c.DBName == "" {
+			errs = append(errs, errors.New("DB_NAME is required"))
+		}
+	}
+
+	switch c.SSLMode {
+	case "", "disable", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Errorf("DB_SSLMODE %q is not one of disable, require, verify-ca, verify-full", c.SSLMode))
+	}
+	if (c.SSLCert == "") != (c.SSLKey == "") {
+		errs = append(errs, errors.New("DB_SSLCERT and DB_SSLKEY must be set together"))
+	}
+	for key, path := range map[string]string{"DB_SSLROOTCERT": c.SSLRootCert, "DB_SSLCERT": c.SSLCert, "DB_SSLKEY": c.SSLKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", key, err))
+		}
+	}
+	if c.ConnectTimeout < 0 {
+		errs = append(errs, fmt.Errorf("DB_CONNECT_TIMEOUT must not be negative, got %d", c.ConnectTimeout))
+	}
+	if c.MaxOpenConns < 0 {
+		errs = append(errs, fmt.Errorf("DB_MAX_OPEN_CONNS must not be negative, got %d", c.MaxOpenConns))
+	}
+	if c.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("DB_MAX_IDLE_CONNS must not be negative, got %d", c.MaxIdleConns))
+	}
+	if c.LinkWorkers <= 0 {
+		errs = append(errs, fmt.Errorf("DB_LINK_WORKERS must be positive, got %d", c.LinkWorkers))
+	}
+	if c.ConnMaxLifetime < 0 {
+		errs = append(errs, fmt.Errorf("DB_CONN_MAX_LIFETIME must not be negative, got %v", c.ConnMaxLifetime))
+	}
+	if c.ConnectRetries < 0 {
+		errs = append(errs, fmt.Errorf("DB_CONNECT_RETRIES must not be negative, got %d", c.ConnectRetries))
+	}
+	if c.ConnectRetries > 0 && c.ConnectBackoff <= 0 {
+		errs = append(errs, fmt.Errorf("DB_CONNECT_BACKOFF must be positive, got %v", c.ConnectBackoff))
+	}
+	return errs
+}
+
+// ConnectionString returns the lib/pq connection string. When a DSN URL is
+// configured, the explicitly set TLS, search_path, application_name and
+// timeout options are added to its query string; otherwise a keyword/value
+// string is built with every value quoted. sslmode defaults to disable for
+// keyword/value connections and to the DSN's own setting for URLs.
 func (c *DatabaseConfig) ConnectionString() string {
-	return fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		c.Host, c.Port, c.User, c.Password, c.DBName,
-	)
+	if c.URL != "" {
+		return c.urlConnectionString()
+	}
+
+	sslMode := c.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	params := []struct{ key, value string }{
+		{"host", c.Host},
+		{"port", strconv.Itoa(c.Port)},
+		{"user", c.User},
+		{"password", c.Password.Value()},
+		{"dbname", c.DBName},
+		{"sslmode", sslMode},
+	}
+	for _, opt := range c.options() {
+		params = append(params, struct{ key, value string }{opt[0], opt[1]})
+	}
+	if c.ApplicationName == "" {
+		params = append(params, struct{ key, value string }{"application_name", defaultApplicationName})
+	}
+
+	var b strings.Builder
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(p.key)
+		b.WriteByte('=')
+		b.WriteString(quoteValue(p.value))
+	}
+	return b.String()
+}
+
+func (c *DatabaseConfig) urlConnectionString() string {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return c.URL // Rejected by Validate
+	}
+
+	query := u.Query()
+	if c.SSLMode != "" {
+		query.Set("sslmode", c.SSLMode)
+	}
+	for _, opt := range c.options() {
+		query.Set(opt[0], opt[1])
+	}
+	if c.ApplicationName == "" && query.Get("application_name") == "" {
+		query.Set("application_name", defaultApplicationName)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// defaultApplicationName identifies the importer's connections when neither
+// the configuration nor the DSN names the application
+const defaultApplicationName = "importer"
+
+// options returns the optional connection parameters that are set
+func (c *DatabaseConfig) options() [][2]string {
+	var opts [][2]string
+	add := func(key, value string) {
+		if value != "" {
+			opts = append(opts, [2]string{key, value})
+		}
+	}
+	add("sslrootcert", c.SSLRootCert)
+	add("sslcert", c.SSLCert)
+	add("sslkey", c.SSLKey)
+	add("search_path", c.SearchPath)
+	add("application_name", c.ApplicationName)
+	if c.ConnectTimeout > 0 {
+		add("connect_timeout", strconv.Itoa(c.ConnectTimeout))
+	}
+	return opts
+}
+
+// quoteValue quotes a keyword/value connection parameter so passwords and
+// paths containing spaces, quotes or backslashes are passed through intact
+func quoteValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+func setFromEnv(key string, dst *string) {
+	if value, exists := os.LookupEnv(key); exists {
+		*dst = value
+	}
 }
 
-func getEnv(key, fallback string) string {
+func setIntFromEnv(key string, dst *int) error {
 	if value, exists := os.LookupEnv(key); exists {
-		return value
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not an integer", key, value)
+		}
+		*dst = v
 	}
-	return fallback
+	return nil
 }
 
-func getEnvAsInt(key string, fallback int) int {
+func setDurationFromEnv(key string, dst *time.Duration) error {
 	if value, exists := os.LookupEnv(key); exists {
-		if v, err := strconv.Atoi(value); err == nil {
-			return v
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration such as 30s or 5m", key, value)
+		}
+		*dst = d
+	}
+	return nil
+}
+
+// setListFromEnv reads a comma-separated list, ignoring empty entries
+func setListFromEnv(key string, dst *[]string) {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return
+	}
+	*dst = nil
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*dst = append(*dst, v)
 		}
 	}
-	return fallback
 }
 
-func getEnvAsBool(key string, fallback bool) bool {
+func setBoolFromEnv(key string, dst *bool) error {
 	if value, exists := os.LookupEnv(key); exists {
 		b, err := strconv.ParseBool(value)
-		if err == nil {
-			return b
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", key, value)
 		}
+		*dst = b
 	}
-	return fallback
+	return nil
 }
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the layout of a YAML config file:
+//
+//	defaults:
+//	  batch_size: 1000
+//	  db:
+//	    host: localhost
+//	profiles:
+//	  prod:
+//	    db:
+//	      host: db.internal
+//
+// Keys mirror the yaml tags of AppConfig. A profile only needs the keys that
+// differ from the defaults section.
+type configFile struct {
+	Defaults yaml.Node            `yaml:"defaults"`
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+}
+
+func loadFile(cfg *AppConfig, filename, profile string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var file configFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", filename, err)
+	}
+
+	if err := decodeSection(cfg, &file.Defaults); err != nil {
+		return fmt.Errorf("config file %s, defaults: %v", filename, err)
+	}
+
+	if profile == "" {
+		return nil
+	}
+	node, ok := file.Profiles[profile]
+	if !ok {
+		return fmt.Errorf("config file %s has no profile %q (available: %s)",
+			filename, profile, strings.Join(profileNames(file.Profiles), ", "))
+	}
+	if err := decodeSection(cfg, &node); err != nil {
+		return fmt.Errorf("config file %s, profile %s: %v", filename, profile, err)
+	}
+	return nil
+}
+
+// decodeSection applies a section over cfg. Keys missing from the section
+// leave the current values in place, unknown keys are rejected.
+func decodeSection(cfg *AppConfig, node *yaml.Node) error {
+	if node.Kind == 0 {
+		return nil
+	}
+
+	// Round-trip through bytes so the strict decoder catches misspelled keys
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	return dec.Decode(cfg)
+}
+
+func profileNames(profiles map[string]yaml.Node) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
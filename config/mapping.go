@@ -0,0 +1,23 @@
+package config
+
+// TableMapping names the table an entity is written to and its columns.
+// Table may be schema-qualified, e.g. crm.customer. Columns maps the
+// importer's column names to the target's; columns left out keep their own
+// name and optional columns mapped to "" are not written.
+type TableMapping struct {
+	Table   string            `yaml:"table"`
+	Columns map[string]string `yaml:"columns"`
+}
+
+// SchemaMapping maps the importer's entities onto the target schema. The
+// zero value targets the tables created by the embedded migrations.
+type SchemaMapping struct {
+	Customers TableMapping `yaml:"customers"`
+	Accounts  TableMapping `yaml:"accounts"`
+	Links     TableMapping `yaml:"links"`
+
+	// SkipAudit writes only the mapped tables: no import_runs ledger,
+	// import_changes log or field history, so the embedded migrations need
+	// not be applied to the target. Runs cannot be listed or rolled back.
+	SkipAudit bool `yaml:"skip_audit"`
+}
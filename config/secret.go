@@ -0,0 +1,83 @@
+package config
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+// Secret is a configuration value that must never be logged. Formatting it
+// with fmt prints a placeholder; use Value to read the actual secret.
+type Secret string
+
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+var (
+	secretsMu    sync.RWMutex
+	secretValues []string
+)
+
+// registerSecret records a value to be scrubbed by Redact
+func registerSecret(value string) {
+	if len(value) < 4 { // Too short to redact without mangling unrelated text
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range secretValues {
+		if v == value {
+			return
+		}
+	}
+	secretValues = append(secretValues, value)
+}
+
+// Redact replaces every loaded secret value in s, for use on error messages
+// and log lines that may embed connection strings or request details
+func Redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, v := range secretValues {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	return s
+}
+
+// RedactingWriter wraps w so every write is passed through Redact
+func RedactingWriter(w io.Writer) io.Writer {
+	return redactingWriter{w: w}
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// SecretProvider looks up secrets by name, e.g. DB_PASSWORD or API_KEY.
+// ok is false when the provider does not hold the secret.
+type SecretProvider interface {
+	Secret(name string) (value string, ok bool, err error)
+}
+
+// secretNames are the settings that may come from a SecretProvider
+var secretNames = []string{"DB_PASSWORD", "API_KEY", "DATABASE_URL"}
+
+// encryptedFile is the on-disk layout of an encrypted secrets file. The
+// plaintext is a JSON object mapping secret names to values, sealed with
+// AES-256-GCM under a key derived from a passphrase with scrypt.
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedFileProvider serves secrets from a local encrypted file
+type EncryptedFileProvider struct {
+	secrets map[string]string
+}
+
+var _ SecretProvider = (*EncryptedFileProvider)(nil)
+
+// NewEncryptedFileProvider decrypts the secrets file with the passphrase
+func NewEncryptedFileProvider(filename, passphrase string) (*EncryptedFileProvider, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %v", err)
+	}
+
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets file %s: %v", filename, err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("secrets file %s has unsupported version %d", filename, file.Version)
+	}
+
+	gcm, err := newGCM(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets file %s: wrong key or corrupted file", filename)
+	}
+
+	p := &EncryptedFileProvider{}
+	if err := json.Unmarshal(plaintext, &p.secrets); err != nil {
+		return nil, fmt.Errorf("secrets file %s does not contain a JSON object", filename)
+	}
+	for _, v := range p.secrets {
+		registerSecret(v)
+	}
+	return p, nil
+}
+
+func (p *EncryptedFileProvider) Secret(name string) (string, bool, error) {
+	v, ok := p.secrets[name]
+	return v, ok, nil
+}
+
+// EncryptSecrets seals a name-to-value map in the format read by
+// NewEncryptedFileProvider
+func EncryptSecrets(secrets map[string]string, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("an encryption passphrase is required")
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(encryptedFile{
+		Version:    1,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	}, "", "  ")
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive secrets key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SecretsKey returns the passphrase of the encrypted secrets file from
+// SECRETS_KEY or the file named by SECRETS_KEY_FILE
+func SecretsKey() (string, error) {
+	var key string
+	if err := setSecretFromEnv("SECRETS_KEY", &key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// defaultSecretProviders returns the providers configured through the
+// environment: the encrypted file named by SECRETS_FILE, if any
+func defaultSecretProviders() ([]SecretProvider, error) {
+	filename := os.Getenv("SECRETS_FILE")
+	if filename == "" {
+		return nil, nil
+	}
+
+	key, err := SecretsKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, errors.New("SECRETS_FILE is set but neither SECRETS_KEY nor SECRETS_KEY_FILE is")
+	}
+
+	p, err := NewEncryptedFileProvider(filename, key)
+	if err != nil {
+		return nil, err
+	}
+	return []SecretProvider{p}, nil
+}
+
+// loadSecrets fills secret settings from the providers, first match wins
+func loadSecrets(cfg *AppConfig, providers []SecretProvider) error {
+	targets := map[string]func(string){
+		"DB_PASSWORD":  func(v string) { cfg.DB.Password = Secret(v) },
+		"API_KEY":      func(v string) { cfg.API.APIKey = Secret(v) },
+		"DATABASE_URL": func(v string) { cfg.DB.URL = v },
+	}
+
+	for _, name := range secretNames {
+		for _, p := range providers {
+			v, ok, err := p.Secret(name)
+			if err != nil {
+				return fmt.Errorf("failed to look up secret %s: %v", name, err)
+			}
+			if ok {
+				targets[name](v)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// setSecretFromEnv reads key directly or, for Docker and Kubernetes secrets,
+// from the file named by key_FILE. Setting both is an error.
+func setSecretFromEnv(key string, dst *string) error {
+	value, direct := os.LookupEnv(key)
+	filename, fromFile := os.LookupEnv(key + "_FILE")
+
+	switch {
+	case direct && fromFile:
+		return fmt.Errorf("%s and %s_FILE are both set", key, key)
+	case direct:
+		*dst = value
+	case fromFile:
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("%s_FILE: %v", key, err)
+		}
+		*dst = strings.TrimRight(string(data), "\r\n")
+	}
+	return nil
+}
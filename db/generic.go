@@ -0,0 +1,326 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"importer/entity"
+
+	"github.com/lib/pq"
+)
+
+var _ entity.Repository = (*PostgresDB)(nil)
+
+// historyTables are the field history tables a definition may log to, with
+// their row ID and key columns
+var historyTables = map[string]struct{ id, key string }{
+	"customer_history": {id: "customer_id", key: "customer_number"},
+	"account_history":  {id: "account_id", key: "account_number"},
+}
+
+// PrepareEntities checks the tables of a definition and generates their
+// upserts. Like the built-in entities, rows are stamped with the run in
+// last_run_id and updated_at when the table has those columns, logged to
+// import_changes for rollback and, with a history table, to the field history.
+func (p *PostgresDB) PrepareEntities(def *entity.Definition) error {
+	upserts := make(map[string]upsert, len(def.Entities))
+	var problems []string
+	for _, e := range def.Entities {
+		name, table, err := quoteTable(e.Table)
+		if err != nil {
+			return fmt.Errorf("entity %s: %v", e.Name, err)
+		}
+		if _, ok := historyTables[e.History]; e.History != "" && !ok {
+			return fmt.Errorf("entity %s: history must be customer_history or account_history, got %q", e.Name, e.History)
+		}
+		// Recorded in import_changes quoted, unlike the built-in entities
+		if len(name) > 63 {
+			return fmt.Errorf("entity %s: table name %s is too long for the change log", e.Name, name)
+		}
+
+		columns := map[string]string{"id": e.IDColumn}
+		var key []string
+		for _, f := range e.Fields {
+			column := entityColumn(e, f)
+			columns[f.Name] = column
+			if contains(e.Key, f.Name) {
+				key = append(key, column)
+			}
+		}
+		for column := range e.Scope {
+			columns[column] = column
+			key = append(key, column)
+		}
+
+		problem, err := checkTable(p.db, name, table, columns, key)
+		if err != nil {
+			return err
+		}
+		if problem != "" {
+			problems = append(problems, problem)
+			continue
+		}
+		existing, err := tableColumns(p.db, name, table)
+		if err != nil {
+			return err
+		}
+		upserts[e.Name] = buildEntityUpsert(e, name, existing, p.audit)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("database schema is not ready for import: %s", strings.Join(problems, "; "))
+	}
+
+	p.entityUpserts = upserts
+	return nil
+}
+
+// entityColumn is the column a field is written to: the ID column of a
+// reference, otherwise the field's own column
+func entityColumn(e *entity.Entity, f entity.Field) string {
+	if ref, ok := e.Reference(f.Name); ok {
+		return ref.Column
+	}
+	return f.Column
+}
+
+// quoteTable quotes a [schema.]table name, also returning the bare table
+func quoteTable(name string) (quoted, table string, err error) {
+	parts := strings.Split(name, ".")
+	switch len(parts) {
+	case 1:
+		return pq.QuoteIdentifier(parts[0]), parts[0], nil
+	case 2:
+		return pq.QuoteIdentifier(parts[0]) + "." + pq.QuoteIdentifier(parts[1]), parts[1], nil
+	}
+	return "", "", fmt.Errorf("table %q is not [schema.]table", name)
+}
+
+// buildEntityUpsert generates an upsert on the entity's key and scope columns,
+// returning the row's ID. Field values are passed by field name, scope values
+// as "scope:" and the column, and the run ID as run_id. existing lists the
+// table's columns. Without audit, changes and history are not logged.
+func buildEntityUpsert(e *entity.Entity, table string, existing map[string]bool, audit bool) upsert {
+	var u upsert
+	placeholder := make(map[string]string)
+	param := func(name string) string {
+		if p, ok := placeholder[name]; ok {
+			return p
+		}
+		u.params = append(u.params, name)
+		placeholder[name] = fmt.Sprintf("$%d", len(u.params))
+		return placeholder[name]
+	}
+
+	var columns, values, match, conflict, set []string
+	var fields, targets []string // Logged to the history
+	for _, f := range e.Fields {
+		column := pq.QuoteIdentifier(entityColumn(e, f))
+		columns = append(columns, column)
+		values = append(values, param(f.Name))
+		if contains(e.Key, f.Name) {
+			match = append(match, fmt.Sprintf("t.%s = %s", column, param(f.Name)))
+			conflict = append(conflict, column)
+			continue
+		}
+		set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+		if _, ok := e.Reference(f.Name); !ok {
+			fields = append(fields, pq.QuoteLiteral(f.Name))
+			targets = append(targets, pq.QuoteLiteral(f.Column))
+		}
+	}
+	scope := make([]string, 0, len(e.Scope))
+	for column := range e.Scope {
+		scope = append(scope, column)
+	}
+	sort.Strings(scope)
+	for _, c := range scope {
+		column := pq.QuoteIdentifier(c)
+		columns = append(columns, column)
+		values = append(values, param("scope:"+c))
+		match = append(match, fmt.Sprintf("t.%s = %s", column, param("scope:"+c)))
+		conflict = append(conflict, column)
+	}
+	if existing["last_run_id"] {
+		columns = append(columns, pq.QuoteIdentifier("last_run_id"))
+		values = append(values, param("run_id"))
+		set = append(set, `"last_run_id" = EXCLUDED."last_run_id"`)
+	}
+	if existing["updated_at"] && len(set) > 0 {
+		set = append(set, `"updated_at" = CURRENT_TIMESTAMP`)
+	}
+	// DO NOTHING would not return the ID of existing rows
+	if len(set) == 0 {
+		set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", conflict[0], conflict[0]))
+	}
+
+	id := pq.QuoteIdentifier(e.IDColumn)
+	if !audit {
+		u.sql = fmt.Sprintf(`
+        INSERT INTO %s AS t (%s)
+        VALUES (%s)
+        ON CONFLICT (%s) DO UPDATE SET %s
+        RETURNING t.%s`, table, strings.Join(columns, ", "), strings.Join(values, ", "),
+			strings.Join(conflict, ", "), strings.Join(set, ", "), id)
+		return u
+	}
+	run := param("run_id")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `
+        WITH prev AS (
+            SELECT t.%s AS id, to_jsonb(t) AS image FROM %s t WHERE %s
+        ), upsert AS (
+            INSERT INTO %s AS t (%s)
+            VALUES (%s)
+            ON CONFLICT (%s) DO UPDATE SET %s
+            RETURNING t.%s AS id, to_jsonb(t) AS image
+        ),`, id, table, strings.Join(match, " AND "),
+		table, strings.Join(columns, ", "), strings.Join(values, ", "),
+		strings.Join(conflict, ", "), strings.Join(set, ", "), id)
+
+	if h, ok := historyTables[e.History]; ok && len(fields) > 0 {
+		fmt.Fprintf(&b, ` history AS (
+            INSERT INTO %s (%s, %s, field, old_value, new_value, run_id)
+            SELECT upsert.id, %s, f.field, prev.image->>f.col, upsert.image->>f.col, %s
+            FROM upsert
+            JOIN prev ON prev.id = upsert.id
+            CROSS JOIN unnest(ARRAY[%s], ARRAY[%s]) AS f(field, col)
+            WHERE prev.image->>f.col IS DISTINCT FROM upsert.image->>f.col
+        ),`, e.History, h.id, h.key, param(e.Key[0]), run,
+			strings.Join(fields, ", "), strings.Join(targets, ", "))
+	}
+
+	fmt.Fprintf(&b, ` change AS (
+            INSERT INTO import_changes (run_id, table_name, row_id, operation, before_image)
+            SELECT %s, %s, upsert.id,
+                   CASE WHEN prev.id IS NULL THEN 'insert' ELSE 'update' END, prev.image
+            FROM upsert LEFT JOIN prev ON prev.id = upsert.id
+            WHERE %s::VARCHAR IS NOT NULL
+        )
+        SELECT id FROM upsert`, run, pq.QuoteLiteral(table), run)
+
+	u.sql = b.String()
+	return u
+}
+
+// UpsertRecords writes the records of a defined entity in batches,
+// replacing reference fields by the referenced IDs. Empty values are
+// written as NULL, scope values as given.
+func (p *PostgresDB) UpsertRecords(e *entity.Entity, records []entity.Record, refs map[string]map[string]int) (map[string]int, error) {
+	u, ok := p.entityUpserts[e.Name]
+	if !ok {
+		return nil, fmt.Errorf("entity %s was not prepared", e.Name)
+	}
+
+	ids := make(map[string]int)
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	stmt, err := tx.Prepare(u.sql)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for i, r := range records {
+		values := map[string]interface{}{"run_id": p.runID}
+		for column, value := range e.Scope {
+			values["scope:"+column] = value
+		}
+		for _, f := range e.Fields {
+			value := r.Values[f.Name]
+			if value == "" {
+				continue
+			}
+			if ref, ok := e.Reference(f.Name); ok {
+				values[f.Name] = refs[ref.Entity][value]
+			} else {
+				values[f.Name] = value
+			}
+		}
+
+		var id int
+		if err := stmt.QueryRow(u.args(values)...).Scan(&id); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to insert %s %s: %v", e.Name, e.KeyOf(r), err)
+		}
+		ids[e.KeyOf(r)] = id
+
+		if (i+1)%p.cfg.BatchSize == 0 {
+			if err := tx.Commit(); err != nil {
+				return nil, fmt.Errorf("failed to commit batch: %v", err)
+			}
+			tx, err = p.db.Begin()
+			if err != nil {
+				return nil, fmt.Errorf("failed to begin new transaction: %v", err)
+			}
+			stmt, err = tx.Prepare(u.sql)
+			if err != nil {
+				tx.Rollback()
+				return nil, fmt.Errorf("failed to prepare statement: %v", err)
+			}
+			log.Printf("Processed %d %s rows", i+1, e.Name)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit final batch: %v", err)
+	}
+	return ids, nil
+}
+
+// isEntityTable reports whether a change log table name is the quoted table
+// of a defined entity rather than a built-in entity
+func isEntityTable(name string) bool {
+	return strings.HasPrefix(name, `"`)
+}
+
+// revertEntityChange reverts a logged change to a table of a defined entity,
+// identifying the row by the table's primary key: an inserted row is deleted
+// and an updated row gets every column of its before-image back
+func revertEntityChange(tx *sql.Tx, c change) (sql.Result, error) {
+	var key []string
+	err := tx.QueryRow(`
+        SELECT array_agg(a.attname::text)
+        FROM pg_index i
+        JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+        WHERE i.indrelid = to_regclass($1) AND i.indisprimary`, c.table).Scan(pq.Array(&key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the primary key of %s: %v", c.table, err)
+	}
+	if len(key) != 1 {
+		return nil, fmt.Errorf("table %s needs a single-column primary key to be rolled back", c.table)
+	}
+	id := pq.QuoteIdentifier(key[0])
+
+	if c.operation == "insert" {
+		return tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s = $1`, c.table, id), c.rowID)
+	}
+
+	var image map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(c.before.String), &image); err != nil {
+		return nil, fmt.Errorf("invalid before-image of %s row %d: %v", c.table, c.rowID, err)
+	}
+	var set []string
+	for column := range image {
+		if column != key[0] {
+			set = append(set, fmt.Sprintf("%s = b.%s", pq.QuoteIdentifier(column), pq.QuoteIdentifier(column)))
+		}
+	}
+	sort.Strings(set)
+	if len(set) == 0 {
+		return tx.Exec(fmt.Sprintf(`SELECT 1 FROM %s WHERE %s = $1`, c.table, id), c.rowID)
+	}
+	return tx.Exec(fmt.Sprintf(`
+        UPDATE %s t SET
+            %s
+        FROM jsonb_populate_record(NULL::%s, $2) b
+        WHERE t.%s = $1`, c.table, strings.Join(set, ",\n            "), c.table, id), c.rowID, c.before.String)
+}
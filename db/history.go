@@ -0,0 +1,58 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// FieldChange is one field of a customer or account changed by an upsert
+type FieldChange struct {
+	Field     string
+	OldValue  string
+	NewValue  string
+	RunID     string
+	ChangedAt time.Time
+}
+
+// CustomerHistory returns the field changes of a customer, oldest first.
+// tenant is empty outside tenant mode.
+func (p *PostgresDB) CustomerHistory(tenant, customerNumber string) ([]FieldChange, error) {
+	return p.history(`
+        SELECT field, COALESCE(old_value, ''), COALESCE(new_value, ''), run_id, changed_at
+        FROM customer_history
+        WHERE tenant_id = $1 AND customer_number = $2
+        ORDER BY id`, tenant, customerNumber)
+}
+
+// AccountHistory returns the field changes of an account, oldest first
+func (p *PostgresDB) AccountHistory(tenant, accountNumber string) ([]FieldChange, error) {
+	return p.history(`
+        SELECT field, COALESCE(old_value, ''), COALESCE(new_value, ''), run_id, changed_at
+        FROM account_history
+        WHERE tenant_id = $1 AND account_number = $2
+        ORDER BY id`, tenant, accountNumber)
+}
+
+func (p *PostgresDB) history(query string, args ...interface{}) ([]FieldChange, error) {
+	if !p.audit {
+		return nil, errNoAudit
+	}
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %v", err)
+	}
+	defer rows.Close()
+
+	var changes []FieldChange
+	for rows.Next() {
+		var c FieldChange
+		var runID sql.NullString
+		if err := rows.Scan(&c.Field, &c.OldValue, &c.NewValue, &runID, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan history: %v", err)
+		}
+		c.RunID = runID.String
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,427 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"importer/config"
+)
+
+// entitySpec lists the columns the importer writes for an entity, by the
+// names used in the embedded migrations
+type entitySpec struct {
+	entity   string   // Recorded in import_changes, and the default table name
+	key      []string // Natural key, the ON CONFLICT target along with tenant_id
+	data     []string // Copied from the row on insert and update
+	audit    []string // Maintained by the importer
+	optional []string // May be mapped to "" when the target has no such column
+}
+
+var (
+	customerSpec = entitySpec{
+		entity:   "customers",
+		key:      []string{"customer_number"},
+		data:     []string{"client_id", "customer_name", "address", "name", "email", "attributes"},
+		audit:    []string{"updated_at", "last_run_id", "tenant_id"},
+		optional: []string{"address", "name", "email", "attributes", "updated_at", "last_run_id", "tenant_id"},
+	}
+	accountSpec = entitySpec{
+		entity:   "accounts",
+		key:      []string{"account_number"},
+		data:     []string{"account_name", "attributes"},
+		audit:    []string{"updated_at", "last_run_id", "tenant_id"},
+		optional: []string{"attributes", "updated_at", "last_run_id", "tenant_id"},
+	}
+	linkSpec = entitySpec{
+		entity:   "customer_accounts",
+		key:      []string{"customer_id", "account_id"},
+		data:     []string{"attributes"},
+		audit:    []string{"last_run_id"},
+		optional: []string{"attributes", "last_run_id"},
+	}
+)
+
+// tableMap is an entity resolved against the configured mapping
+type tableMap struct {
+	spec    entitySpec
+	schema  string // Empty to resolve the table through the search_path
+	table   string
+	columns map[string]string // Target column of every column written
+}
+
+func newTableMap(spec entitySpec, m config.TableMapping) (*tableMap, error) {
+	t := &tableMap{spec: spec, table: spec.entity, columns: make(map[string]string)}
+	if m.Table != "" {
+		parts := strings.Split(m.Table, ".")
+		switch len(parts) {
+		case 1:
+			t.table = parts[0]
+		case 2:
+			t.schema, t.table = parts[0], parts[1]
+		default:
+			return nil, fmt.Errorf("mapping for %s: table %q is not [schema.]table", spec.entity, m.Table)
+		}
+	}
+
+	known := make(map[string]bool)
+	for _, col := range t.allColumns() {
+		known[col] = true
+		t.columns[col] = col
+	}
+	for col, target := range m.Columns {
+		if !known[col] {
+			return nil, fmt.Errorf("mapping for %s: unknown column %q, expected one of %s",
+				spec.entity, col, strings.Join(t.allColumns(), ", "))
+		}
+		if target == "" {
+			if !contains(spec.optional, col) {
+				return nil, fmt.Errorf("mapping for %s: column %q is required", spec.entity, col)
+			}
+			delete(t.columns, col)
+			continue
+		}
+		t.columns[col] = target
+	}
+	return t, nil
+}
+
+func (t *tableMap) allColumns() []string {
+	cols := append([]string{"id"}, t.spec.key...)
+	cols = append(cols, t.spec.data...)
+	return append(cols, t.spec.audit...)
+}
+
+// name is the quoted, possibly schema-qualified table name
+func (t *tableMap) name() string {
+	if t.schema == "" {
+		return pq.QuoteIdentifier(t.table)
+	}
+	return pq.QuoteIdentifier(t.schema) + "." + pq.QuoteIdentifier(t.table)
+}
+
+func (t *tableMap) has(col string) bool {
+	_, ok := t.columns[col]
+	return ok
+}
+
+// col is the quoted target column
+func (t *tableMap) col(col string) string {
+	return pq.QuoteIdentifier(t.columns[col])
+}
+
+// present filters cols to those the target has
+func (t *tableMap) present(cols []string) []string {
+	var result []string
+	for _, c := range cols {
+		if t.has(c) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// conflictKey is the unique key the upsert relies on
+func (t *tableMap) conflictKey() []string {
+	if t.has("tenant_id") {
+		return append([]string{"tenant_id"}, t.spec.key...)
+	}
+	return t.spec.key
+}
+
+// schemaMap is the configured target of every entity
+type schemaMap struct {
+	customers *tableMap
+	accounts  *tableMap
+	links     *tableMap
+	audit     bool // Log runs, changes and history in the importer's own tables
+}
+
+func newSchemaMap(m config.SchemaMapping, tenantMode bool) (*schemaMap, error) {
+	s := &schemaMap{audit: !m.SkipAudit}
+	var err error
+	if s.customers, err = newTableMap(customerSpec, m.Customers); err != nil {
+		return nil, err
+	}
+	if s.accounts, err = newTableMap(accountSpec, m.Accounts); err != nil {
+		return nil, err
+	}
+	if s.links, err = newTableMap(linkSpec, m.Links); err != nil {
+		return nil, err
+	}
+
+	if tenantMode && (!s.customers.has("tenant_id") || !s.accounts.has("tenant_id")) {
+		return nil, fmt.Errorf("tenant mode needs a tenant_id column on customers and accounts")
+	}
+	return s, nil
+}
+
+func (s *schemaMap) tables() []*tableMap {
+	return []*tableMap{s.customers, s.accounts, s.links}
+}
+
+// upsert is a generated statement and the names of its parameters in order
+type upsert struct {
+	sql    string
+	params []string
+}
+
+// args orders the values of a row as the statement's parameters
+func (u upsert) args(values map[string]interface{}) []interface{} {
+	args := make([]interface{}, len(u.params))
+	for i, p := range u.params {
+		args[i] = values[p]
+	}
+	return args
+}
+
+// buildUpsert generates the upsert of an entity. Besides writing the row it
+// logs the row's before-image, or the fact that it was inserted, to
+// import_changes while a run is active, unless audit is off, and records
+// changed fields in historyTable when one is given. The run ID is passed as
+// the run_id parameter.
+func buildUpsert(t *tableMap, historyTable, historyID string, audit bool) upsert {
+	var u upsert
+	placeholder := make(map[string]string)
+	param := func(name string) string {
+		if p, ok := placeholder[name]; ok {
+			return p
+		}
+		u.params = append(u.params, name)
+		placeholder[name] = fmt.Sprintf("$%d", len(u.params))
+		return placeholder[name]
+	}
+
+	var insertCols, values []string
+	for _, c := range t.present(append(append([]string(nil), t.spec.key...), t.spec.data...)) {
+		insertCols = append(insertCols, t.col(c))
+		if c == "attributes" {
+			values = append(values, param(c)+"::JSONB")
+		} else {
+			values = append(values, param(c))
+		}
+	}
+	if t.has("tenant_id") {
+		insertCols = append(insertCols, t.col("tenant_id"))
+		values = append(values, param("tenant_id"))
+	}
+	if t.has("last_run_id") {
+		insertCols = append(insertCols, t.col("last_run_id"))
+		values = append(values, param("run_id"))
+	}
+
+	var match, conflict []string
+	for _, c := range t.conflictKey() {
+		match = append(match, fmt.Sprintf("t.%s = %s", t.col(c), param(c)))
+		conflict = append(conflict, t.col(c))
+	}
+
+	var set []string
+	for _, c := range t.present(append(append([]string(nil), t.spec.data...), "last_run_id")) {
+		if c == "attributes" {
+			// Merged so attributes not in this file, or not whitelisted, are kept
+			set = append(set, fmt.Sprintf("%s = COALESCE(t.%s, '{}') || EXCLUDED.%s", t.col(c), t.col(c), t.col(c)))
+			continue
+		}
+		set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", t.col(c), t.col(c)))
+	}
+	if t.has("updated_at") && len(set) > 0 {
+		set = append(set, fmt.Sprintf("%s = CURRENT_TIMESTAMP", t.col("updated_at")))
+	}
+	action := "DO NOTHING"
+	if len(set) > 0 {
+		action = "DO UPDATE SET\n                " + strings.Join(set, ",\n                ")
+	}
+
+	if !audit {
+		u.sql = fmt.Sprintf(`
+        INSERT INTO %s AS t (%s)
+        VALUES (%s)
+        ON CONFLICT (%s) %s
+        RETURNING t.%s`, t.name(), strings.Join(insertCols, ", "), strings.Join(values, ", "),
+			strings.Join(conflict, ", "), action, t.col("id"))
+		return u
+	}
+	run := param("run_id")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `
+        WITH prev AS (
+            SELECT t.%s AS id, to_jsonb(t) AS image FROM %s t WHERE %s
+        ), upsert AS (
+            INSERT INTO %s AS t (%s)
+            VALUES (%s)
+            ON CONFLICT (%s) %s
+            RETURNING t.%s AS id, to_jsonb(t) AS image
+        ),`, t.col("id"), t.name(), strings.Join(match, " AND "),
+		t.name(), strings.Join(insertCols, ", "), strings.Join(values, ", "),
+		strings.Join(conflict, ", "), action, t.col("id"))
+
+	if historyTable != "" {
+		var fields, targets []string
+		for _, c := range t.present(t.spec.data) {
+			fields = append(fields, pq.QuoteLiteral(c))
+			targets = append(targets, pq.QuoteLiteral(t.columns[c]))
+		}
+		tenant := "''"
+		if t.has("tenant_id") {
+			tenant = param("tenant_id")
+		}
+		key := t.spec.key[0]
+		fmt.Fprintf(&b, ` history AS (
+            INSERT INTO %s (%s, tenant_id, %s, field, old_value, new_value, run_id)
+            SELECT upsert.id, %s, %s, f.field, prev.image->>f.col, upsert.image->>f.col, %s
+            FROM upsert
+            JOIN prev ON prev.id = upsert.id
+            CROSS JOIN unnest(ARRAY[%s], ARRAY[%s]) AS f(field, col)
+            WHERE prev.image->>f.col IS DISTINCT FROM upsert.image->>f.col
+        ),`, historyTable, historyID, key, tenant, param(key), run,
+			strings.Join(fields, ", "), strings.Join(targets, ", "))
+	}
+
+	fmt.Fprintf(&b, ` change AS (
+            INSERT INTO import_changes (run_id, table_name, row_id, operation, before_image)
+            SELECT %s, %s, upsert.id,
+                   CASE WHEN prev.id IS NULL THEN 'insert' ELSE 'update' END, prev.image
+            FROM upsert LEFT JOIN prev ON prev.id = upsert.id
+            WHERE %s::VARCHAR IS NOT NULL
+        )
+        SELECT id FROM upsert`, run, pq.QuoteLiteral(t.spec.entity), run)
+
+	u.sql = b.String()
+	return u
+}
+
+// buildRestore generates the statement that puts a row back to a logged
+// before-image, or "" when no column of the entity is ever updated
+func buildRestore(t *tableMap) string {
+	var set []string
+	for _, c := range t.present(append(append([]string(nil), t.spec.data...), "updated_at", "last_run_id")) {
+		set = append(set, fmt.Sprintf("%s = b.%s", t.col(c), t.col(c)))
+	}
+	if len(set) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(`
+        UPDATE %s t SET
+            %s
+        FROM jsonb_populate_record(NULL::%s, $2) b
+        WHERE t.%s = $1`, t.name(), strings.Join(set, ",\n            "), t.name(), t.col("id"))
+}
+
+// selectText selects a column as text, or the empty string when the target
+// has no such column
+func (t *tableMap) selectText(alias, col string) string {
+	if !t.has(col) {
+		return "''"
+	}
+	return fmt.Sprintf("COALESCE(%s.%s::TEXT, '')", alias, t.col(col))
+}
+
+// statements are the generated SQL of the Postgres repository
+type statements struct {
+	upsertCustomer upsert
+	upsertAccount  upsert
+	insertLink     upsert
+
+	// By entity name as recorded in import_changes
+	restore map[string]string
+	remove  map[string]string
+
+	exportCustomers string
+	exportAccounts  string
+	exportLinks     string
+
+	// IDs of existing rows by tenant and number, given as two text arrays
+	lookupCustomers string
+	lookupAccounts  string
+
+	// Links logged by later runs to customers or accounts a run inserted,
+	// given the run ID and the rolled back status
+	laterLinks string
+}
+
+func newStatements(s *schemaMap) *statements {
+	st := &statements{
+		upsertCustomer: buildUpsert(s.customers, "customer_history", "customer_id", s.audit),
+		upsertAccount:  buildUpsert(s.accounts, "account_history", "account_id", s.audit),
+		insertLink:     buildUpsert(s.links, "", "", s.audit),
+		restore:        make(map[string]string),
+		remove:         make(map[string]string),
+	}
+	for _, t := range s.tables() {
+		st.restore[t.spec.entity] = buildRestore(t)
+		st.remove[t.spec.entity] = fmt.Sprintf(`DELETE FROM %s WHERE %s = $1`, t.name(), t.col("id"))
+	}
+
+	c, a, l := s.customers, s.accounts, s.links
+	st.exportCustomers = fmt.Sprintf(`
+        SELECT %s, c.%s, c.%s, %s, %s, %s, %s, %s
+        FROM %s c ORDER BY 7, 2`,
+		c.selectText("c", "client_id"), c.col("customer_number"), c.col("customer_name"),
+		c.selectText("c", "address"), c.selectText("c", "name"), c.selectText("c", "email"),
+		c.selectText("c", "tenant_id"), c.selectText("c", "attributes"), c.name())
+	st.exportAccounts = fmt.Sprintf(`
+        SELECT a.%s, a.%s, %s, %s
+        FROM %s a ORDER BY 3, 1`,
+		a.col("account_number"), a.col("account_name"), a.selectText("a", "tenant_id"),
+		a.selectText("a", "attributes"), a.name())
+	st.exportLinks = fmt.Sprintf(`
+        SELECT c.%s, a.%s, %s, %s
+        FROM %s ca
+        JOIN %s c ON c.%s = ca.%s
+        JOIN %s a ON a.%s = ca.%s
+        ORDER BY 3, 1, 2`,
+		c.col("customer_number"), a.col("account_number"), c.selectText("c", "tenant_id"),
+		l.selectText("ca", "attributes"), l.name(),
+		c.name(), c.col("id"), l.col("customer_id"),
+		a.name(), a.col("id"), l.col("account_id"))
+	st.lookupCustomers = buildLookup(c, "customer_number")
+	st.lookupAccounts = buildLookup(a, "account_number")
+	st.laterLinks = buildLaterLinks(l)
+	return st
+}
+
+// buildLaterLinks finds the links logged by later runs whose customer or
+// account was inserted by the run. Entity definition tables are logged
+// quoted, so "customers" and customers are the same table.
+func buildLaterLinks(l *tableMap) string {
+	return fmt.Sprintf(`
+        SELECT DISTINCT later.row_id, later.run_id
+        FROM import_changes c
+        JOIN %s ca
+          ON btrim(c.table_name, '"') = '%s' AND ca.%s = c.row_id
+          OR btrim(c.table_name, '"') = '%s' AND ca.%s = c.row_id
+        JOIN import_changes later
+          ON btrim(later.table_name, '"') = '%s'
+         AND later.row_id = ca.%s
+         AND later.id > c.id
+         AND later.run_id <> c.run_id
+        JOIN import_runs r ON r.run_id = later.run_id
+        WHERE c.run_id = $1 AND c.operation = 'insert' AND r.status <> $2
+        ORDER BY later.row_id`,
+		l.name(), customerSpec.entity, l.col("customer_id"), accountSpec.entity, l.col("account_id"),
+		linkSpec.entity, l.col("id"))
+}
+
+// buildLookup selects the ID, tenant and number of the rows matching the
+// (tenant, number) pairs passed as the arrays $1 and $2
+func buildLookup(t *tableMap, number string) string {
+	return fmt.Sprintf(`
+        SELECT t.%s, %s, t.%s
+        FROM %s t
+        JOIN unnest($1::TEXT[], $2::TEXT[]) AS k(tenant, number)
+          ON t.%s = k.number AND %s = k.tenant`,
+		t.col("id"), t.selectText("t", "tenant_id"), t.col(number), t.name(),
+		t.col(number), t.selectText("t", "tenant_id"))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"importer/config"
+)
+
+// squash collapses whitespace so generated SQL compares by tokens
+func squash(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
+
+// crmCustomers maps customers to a table without the optional columns
+var crmCustomers = config.TableMapping{
+	Table: "crm.customer",
+	Columns: map[string]string{
+		"id": "cust_id", "customer_number": "cust_no",
+		"address": "", "name": "", "email": "", "attributes": "",
+		"updated_at": "", "last_run_id": "", "tenant_id": "",
+	},
+}
+
+func mustTableMap(t *testing.T, spec entitySpec, m config.TableMapping) *tableMap {
+	t.Helper()
+	tm, err := newTableMap(spec, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+func TestNewTableMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    entitySpec
+		mapping config.TableMapping
+		table   string
+		columns map[string]string // Expected targets, "" for columns left out
+		wantErr bool
+	}{
+		{
+			name:    "defaults",
+			spec:    customerSpec,
+			table:   `"customers"`,
+			columns: map[string]string{"id": "id", "customer_number": "customer_number", "tenant_id": "tenant_id"},
+		},
+		{
+			name:    "schema-qualified and renamed",
+			spec:    customerSpec,
+			mapping: crmCustomers,
+			table:   `"crm"."customer"`,
+			columns: map[string]string{"id": "cust_id", "customer_number": "cust_no", "client_id": "client_id", "email": "", "tenant_id": ""},
+		},
+		{
+			name:    "quoted names",
+			spec:    accountSpec,
+			mapping: config.TableMapping{Table: `Sales."Account"`, Columns: map[string]string{"account_name": "Name"}},
+			table:   `"Sales"."""Account"""`,
+			columns: map[string]string{"account_name": "Name"},
+		},
+		{
+			name:    "link table",
+			spec:    linkSpec,
+			mapping: config.TableMapping{Table: "customer_account", Columns: map[string]string{"customer_id": "cust_id", "attributes": ""}},
+			table:   `"customer_account"`,
+			columns: map[string]string{"customer_id": "cust_id", "account_id": "account_id", "attributes": ""},
+		},
+		{
+			name:    "required column left out",
+			spec:    customerSpec,
+			mapping: config.TableMapping{Columns: map[string]string{"customer_name": ""}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown column",
+			spec:    accountSpec,
+			mapping: config.TableMapping{Columns: map[string]string{"email": "mail"}},
+			wantErr: true,
+		},
+		{
+			name:    "too many name parts",
+			spec:    accountSpec,
+			mapping: config.TableMapping{Table: "db.crm.account"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm, err := newTableMap(tt.spec, tt.mapping)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tm.name() != tt.table {
+				t.Errorf("name %s, want %s", tm.name(), tt.table)
+			}
+			for col, want := range tt.columns {
+				if got := tm.columns[col]; got != want {
+					t.Errorf("column %s maps to %q, want %q", col, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSchemaMapTenantMode(t *testing.T) {
+	if _, err := newSchemaMap(config.SchemaMapping{}, true); err != nil {
+		t.Errorf("default schema: %v", err)
+	}
+	if _, err := newSchemaMap(config.SchemaMapping{Customers: crmCustomers}, true); err == nil {
+		t.Error("expected an error for customers without tenant_id in tenant mode")
+	}
+	if _, err := newSchemaMap(config.SchemaMapping{Customers: crmCustomers}, false); err != nil {
+		t.Errorf("without tenant mode: %v", err)
+	}
+}
+
+func TestBuildUpsert(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         entitySpec
+		mapping      config.TableMapping
+		historyTable string
+		historyID    string
+		audit        bool
+		sql          string
+		params       []string
+	}{
+		{
+			name:    "mapped without audit",
+			spec:    customerSpec,
+			mapping: crmCustomers,
+			sql: `INSERT INTO "crm"."customer" AS t ("cust_no", "client_id", "customer_name") VALUES ($1, $2, $3) ` +
+				`ON CONFLICT ("cust_no") DO UPDATE SET "client_id" = EXCLUDED."client_id", "customer_name" = EXCLUDED."customer_name" ` +
+				`RETURNING t."cust_id"`,
+			params: []string{"customer_number", "client_id", "customer_name"},
+		},
+		{
+			name:    "link without optional columns",
+			spec:    linkSpec,
+			mapping: config.TableMapping{Columns: map[string]string{"attributes": "", "last_run_id": ""}},
+			sql: `INSERT INTO "customer_accounts" AS t ("customer_id", "account_id") VALUES ($1, $2) ` +
+				`ON CONFLICT ("customer_id", "account_id") DO NOTHING RETURNING t."id"`,
+			params: []string{"customer_id", "account_id"},
+		},
+		{
+			name:  "tenant key with audit",
+			spec:  accountSpec,
+			audit: true,
+			sql: `WITH prev AS ( SELECT t."id" AS id, to_jsonb(t) AS image FROM "accounts" t WHERE t."tenant_id" = $4 AND t."account_number" = $1 ), ` +
+				`upsert AS ( INSERT INTO "accounts" AS t ("account_number", "account_name", "attributes", "tenant_id", "last_run_id") ` +
+				`VALUES ($1, $2, $3::JSONB, $4, $5) ON CONFLICT ("tenant_id", "account_number") DO UPDATE SET ` +
+				`"account_name" = EXCLUDED."account_name", "attributes" = COALESCE(t."attributes", '{}') || EXCLUDED."attributes", ` +
+				`"last_run_id" = EXCLUDED."last_run_id", "updated_at" = CURRENT_TIMESTAMP RETURNING t."id" AS id, to_jsonb(t) AS image ), ` +
+				`change AS ( INSERT INTO import_changes (run_id, table_name, row_id, operation, before_image) ` +
+				`SELECT $5, 'accounts', upsert.id, CASE WHEN prev.id IS NULL THEN 'insert' ELSE 'update' END, prev.image ` +
+				`FROM upsert LEFT JOIN prev ON prev.id = upsert.id WHERE $5::VARCHAR IS NOT NULL ) SELECT id FROM upsert`,
+			params: []string{"account_number", "account_name", "attributes", "tenant_id", "run_id"},
+		},
+		{
+			name:         "history with audit",
+			spec:         customerSpec,
+			historyTable: "customer_history",
+			historyID:    "customer_id",
+			audit:        true,
+			sql: `WITH prev AS ( SELECT t."id" AS id, to_jsonb(t) AS image FROM "customers" t WHERE t."tenant_id" = $8 AND t."customer_number" = $1 ), ` +
+				`upsert AS ( INSERT INTO "customers" AS t ("customer_number", "client_id", "customer_name", "address", "name", "email", "attributes", "tenant_id", "last_run_id") ` +
+				`VALUES ($1, $2, $3, $4, $5, $6, $7::JSONB, $8, $9) ON CONFLICT ("tenant_id", "customer_number") DO UPDATE SET ` +
+				`"client_id" = EXCLUDED."client_id", "customer_name" = EXCLUDED."customer_name", "address" = EXCLUDED."address", ` +
+				`"name" = EXCLUDED."name", "email" = EXCLUDED."email", "attributes" = COALESCE(t."attributes", '{}') || EXCLUDED."attributes", ` +
+				`"last_run_id" = EXCLUDED."last_run_id", "updated_at" = CURRENT_TIMESTAMP RETURNING t."id" AS id, to_jsonb(t) AS image ), ` +
+				`history AS ( INSERT INTO customer_history (customer_id, tenant_id, customer_number, field, old_value, new_value, run_id) ` +
+				`SELECT upsert.id, $8, $1, f.field, prev.image->>f.col, upsert.image->>f.col, $9 FROM upsert JOIN prev ON prev.id = upsert.id ` +
+				`CROSS JOIN unnest(ARRAY['client_id', 'customer_name', 'address', 'name', 'email', 'attributes'], ` +
+				`ARRAY['client_id', 'customer_name', 'address', 'name', 'email', 'attributes']) AS f(field, col) ` +
+				`WHERE prev.image->>f.col IS DISTINCT FROM upsert.image->>f.col ), ` +
+				`change AS ( INSERT INTO import_changes (run_id, table_name, row_id, operation, before_image) ` +
+				`SELECT $9, 'customers', upsert.id, CASE WHEN prev.id IS NULL THEN 'insert' ELSE 'update' END, prev.image ` +
+				`FROM upsert LEFT JOIN prev ON prev.id = upsert.id WHERE $9::VARCHAR IS NOT NULL ) SELECT id FROM upsert`,
+			params: []string{"customer_number", "client_id", "customer_name", "address", "name", "email", "attributes", "tenant_id", "run_id"},
+		},
+		{
+			name:         "history of renamed columns without tenant",
+			spec:         customerSpec,
+			mapping:      crmCustomers,
+			historyTable: "customer_history",
+			historyID:    "customer_id",
+			audit:        true,
+			sql: `WITH prev AS ( SELECT t."cust_id" AS id, to_jsonb(t) AS image FROM "crm"."customer" t WHERE t."cust_no" = $1 ), ` +
+				`upsert AS ( INSERT INTO "crm"."customer" AS t ("cust_no", "client_id", "customer_name") VALUES ($1, $2, $3) ` +
+				`ON CONFLICT ("cust_no") DO UPDATE SET "client_id" = EXCLUDED."client_id", "customer_name" = EXCLUDED."customer_name" ` +
+				`RETURNING t."cust_id" AS id, to_jsonb(t) AS image ), ` +
+				`history AS ( INSERT INTO customer_history (customer_id, tenant_id, customer_number, field, old_value, new_value, run_id) ` +
+				`SELECT upsert.id, '', $1, f.field, prev.image->>f.col, upsert.image->>f.col, $4 FROM upsert JOIN prev ON prev.id = upsert.id ` +
+				`CROSS JOIN unnest(ARRAY['client_id', 'customer_name'], ARRAY['client_id', 'customer_name']) AS f(field, col) ` +
+				`WHERE prev.image->>f.col IS DISTINCT FROM upsert.image->>f.col ), ` +
+				`change AS ( INSERT INTO import_changes (run_id, table_name, row_id, operation, before_image) ` +
+				`SELECT $4, 'customers', upsert.id, CASE WHEN prev.id IS NULL THEN 'insert' ELSE 'update' END, prev.image ` +
+				`FROM upsert LEFT JOIN prev ON prev.id = upsert.id WHERE $4::VARCHAR IS NOT NULL ) SELECT id FROM upsert`,
+			params: []string{"customer_number", "client_id", "customer_name", "run_id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := buildUpsert(mustTableMap(t, tt.spec, tt.mapping), tt.historyTable, tt.historyID, tt.audit)
+			if got := squash(u.sql); got != tt.sql {
+				t.Errorf("sql:\n got %s\nwant %s", got, tt.sql)
+			}
+			if !reflect.DeepEqual(u.params, tt.params) {
+				t.Errorf("params %q, want %q", u.params, tt.params)
+			}
+		})
+	}
+}
+
+func TestBuildUpsertHistoryTargets(t *testing.T) {
+	m := config.TableMapping{Columns: map[string]string{"customer_name": "full_name"}}
+	u := buildUpsert(mustTableMap(t, customerSpec, m), "customer_history", "customer_id", true)
+	// Fields are recorded by their importer name, read from the target column
+	want := `unnest(ARRAY['client_id', 'customer_name', 'address', 'name', 'email', 'attributes'], ` +
+		`ARRAY['client_id', 'full_name', 'address', 'name', 'email', 'attributes'])`
+	if !strings.Contains(squash(u.sql), want) {
+		t.Errorf("sql %s\nlacks %s", squash(u.sql), want)
+	}
+}
+
+func TestUpsertArgs(t *testing.T) {
+	u := upsert{params: []string{"customer_number", "tenant_id", "run_id"}}
+	got := u.args(map[string]interface{}{"run_id": "r1", "customer_number": "C1", "unused": 1})
+	want := []interface{}{"C1", nil, "r1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBuildRestore(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    entitySpec
+		mapping config.TableMapping
+		sql     string
+	}{
+		{
+			name: "customers",
+			spec: customerSpec,
+			sql: `UPDATE "customers" t SET "client_id" = b."client_id", "customer_name" = b."customer_name", "address" = b."address", ` +
+				`"name" = b."name", "email" = b."email", "attributes" = b."attributes", "updated_at" = b."updated_at", ` +
+				`"last_run_id" = b."last_run_id" FROM jsonb_populate_record(NULL::"customers", $2) b WHERE t."id" = $1`,
+		},
+		{
+			name:    "mapped",
+			spec:    customerSpec,
+			mapping: crmCustomers,
+			sql: `UPDATE "crm"."customer" t SET "client_id" = b."client_id", "customer_name" = b."customer_name" ` +
+				`FROM jsonb_populate_record(NULL::"crm"."customer", $2) b WHERE t."cust_id" = $1`,
+		},
+		{
+			name: "links",
+			spec: linkSpec,
+			sql: `UPDATE "customer_accounts" t SET "attributes" = b."attributes", "last_run_id" = b."last_run_id" ` +
+				`FROM jsonb_populate_record(NULL::"customer_accounts", $2) b WHERE t."id" = $1`,
+		},
+		{
+			name:    "nothing to restore",
+			spec:    linkSpec,
+			mapping: config.TableMapping{Columns: map[string]string{"attributes": "", "last_run_id": ""}},
+			sql:     "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := squash(buildRestore(mustTableMap(t, tt.spec, tt.mapping))); got != tt.sql {
+				t.Errorf("got  %s\nwant %s", got, tt.sql)
+			}
+		})
+	}
+}
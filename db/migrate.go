@@ -0,0 +1,324 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"importer/config"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// migrationLockID serializes concurrent migrate runs against one database
+const migrationLockID = 727274655
+
+// Migration is one versioned schema change, read from
+// migrations/<version>_<name>.up.sql and the matching .down.sql
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var migrations = mustLoadMigrations()
+
+func mustLoadMigrations() []Migration {
+	files, err := migrationFS.ReadDir("migrations")
+	if err != nil {
+		panic(err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, f := range files {
+		name := f.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			panic(fmt.Sprintf("unexpected migration file %s", name))
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionStr, migrationName, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil {
+			panic(fmt.Sprintf("migration file %s is not named <version>_<name>.%s.sql", name, direction))
+		}
+
+		data, err := migrationFS.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			panic(err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	var result []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			panic(fmt.Sprintf("migration %d needs both an up and a down file", m.Version))
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result
+}
+
+// LatestVersion is the schema version this build of the importer expects
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrator applies and reverts the embedded migrations
+type Migrator struct {
+	db *sql.DB
+}
+
+func NewMigrator(cfg *config.AppConfig) (*Migrator, error) {
+	db, err := open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db}, nil
+}
+
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+func (m *Migrator) ensureTable() error {
+	_, err := m.db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+        )`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	return nil
+}
+
+// Up applies every pending migration up to and including target, or all of
+// them when target is 0
+func (m *Migrator) Up(target int) error {
+	if err := m.ensureTable(); err != nil {
+		return err
+	}
+	if target == 0 {
+		target = LatestVersion()
+	}
+
+	count := 0
+	for {
+		// Read the applied versions under the lock, so a concurrent run that
+		// applied a migration meanwhile is seen
+		var mig *Migration
+		err := m.inTx(func(tx *sql.Tx) error {
+			applied, err := m.applied(tx)
+			if err != nil {
+				return err
+			}
+			mig = nextPending(applied, target)
+			if mig == nil {
+				return nil
+			}
+
+			log.Printf("Applying migration %04d_%s...", mig.Version, mig.Name)
+			if _, err := tx.Exec(mig.Up); err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %v", mig.Version, mig.Name, err)
+			}
+			if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.Version, mig.Name); err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %v", mig.Version, mig.Name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if mig == nil {
+			break
+		}
+		count++
+	}
+
+	log.Printf("Applied %d migrations", count)
+	return nil
+}
+
+// Down reverts the most recent steps migrations
+func (m *Migrator) Down(steps int) error {
+	if err := m.ensureTable(); err != nil {
+		return err
+	}
+
+	count := 0
+	for count < steps {
+		var mig *Migration
+		err := m.inTx(func(tx *sql.Tx) error {
+			applied, err := m.applied(tx)
+			if err != nil {
+				return err
+			}
+			mig = lastApplied(applied)
+			if mig == nil {
+				return nil
+			}
+
+			log.Printf("Reverting migration %04d_%s...", mig.Version, mig.Name)
+			if _, err := tx.Exec(mig.Down); err != nil {
+				return fmt.Errorf("reverting migration %04d_%s failed: %v", mig.Version, mig.Name, err)
+			}
+			if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, mig.Version); err != nil {
+				return fmt.Errorf("reverting migration %04d_%s failed: %v", mig.Version, mig.Name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if mig == nil {
+			break
+		}
+		count++
+	}
+
+	log.Printf("Reverted %d migrations", count)
+	return nil
+}
+
+// nextPending returns the oldest migration up to target not yet applied
+func nextPending(applied map[int]time.Time, target int) *Migration {
+	for i := range migrations {
+		if migrations[i].Version > target {
+			break
+		}
+		if _, ok := applied[migrations[i].Version]; !ok {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
+
+// lastApplied returns the most recent applied migration
+func lastApplied(applied map[int]time.Time) *Migration {
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[migrations[i].Version]; ok {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
+
+// Status lists every known migration and when it was applied
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, mig := range migrations {
+		status[i].Migration = mig
+		if at, ok := applied[mig.Version]; ok {
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
+
+// applied reads the applied versions, through tx when called under the
+// migration lock
+func (m *Migrator) applied(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}) (map[int]time.Time, error) {
+	rows, err := q.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func (m *Migrator) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkSchemaVersion refuses to run against a database whose schema version
+// differs from the one this build was written for
+func checkSchemaVersion(db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check schema version: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("database has no schema_migrations table, run 'importer migrate up' first")
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	latest := LatestVersion()
+	switch {
+	case current < latest:
+		return fmt.Errorf("database schema version %d is older than %d, run 'importer migrate up'", current, latest)
+	case current > latest:
+		return fmt.Errorf("database schema version %d is newer than this importer understands (%d), upgrade the importer", current, latest)
+	}
+	return nil
+}
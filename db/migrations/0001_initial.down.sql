@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS customer_accounts;
+DROP TABLE IF EXISTS accounts;
+DROP TABLE IF EXISTS customers;
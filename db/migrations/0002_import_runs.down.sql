@@ -0,0 +1,4 @@
+ALTER TABLE customer_accounts DROP COLUMN IF EXISTS last_run_id;
+ALTER TABLE accounts DROP COLUMN IF EXISTS last_run_id;
+ALTER TABLE customers DROP COLUMN IF EXISTS last_run_id;
+DROP TABLE IF EXISTS import_runs;
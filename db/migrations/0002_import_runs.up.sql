@@ -0,0 +1,27 @@
+-- Create import run ledger
+CREATE TABLE IF NOT EXISTS import_runs (
+    run_id VARCHAR(64) PRIMARY KEY,
+    file_name TEXT NOT NULL,
+    file_sha256 CHAR(64) NOT NULL,
+    operator VARCHAR(255) NOT NULL,
+    backend VARCHAR(20) NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    customer_count INTEGER NOT NULL DEFAULT 0,
+    account_count INTEGER NOT NULL DEFAULT 0,
+    link_count INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL,
+    error_summary TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_runs_started_at ON import_runs(started_at);
+CREATE INDEX IF NOT EXISTS idx_import_runs_file_sha256 ON import_runs(file_sha256);
+
+-- Stamp rows with the run that last touched them
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS last_run_id VARCHAR(64);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS last_run_id VARCHAR(64);
+ALTER TABLE customer_accounts ADD COLUMN IF NOT EXISTS last_run_id VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_customers_last_run_id ON customers(last_run_id);
+CREATE INDEX IF NOT EXISTS idx_accounts_last_run_id ON accounts(last_run_id);
+CREATE INDEX IF NOT EXISTS idx_customer_accounts_last_run_id ON customer_accounts(last_run_id);
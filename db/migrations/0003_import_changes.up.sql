@@ -0,0 +1,13 @@
+-- Create change log used to roll back an import run
+CREATE TABLE IF NOT EXISTS import_changes (
+    id BIGSERIAL PRIMARY KEY,
+    run_id VARCHAR(64) NOT NULL REFERENCES import_runs(run_id) ON DELETE CASCADE,
+    table_name VARCHAR(63) NOT NULL,
+    row_id INTEGER NOT NULL,
+    operation VARCHAR(10) NOT NULL,
+    before_image JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_changes_run_id ON import_changes(run_id);
+CREATE INDEX IF NOT EXISTS idx_import_changes_row ON import_changes(table_name, row_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS account_history;
+DROP TABLE IF EXISTS customer_history;
@@ -0,0 +1,28 @@
+-- Create field-level change history for customers and accounts
+CREATE TABLE IF NOT EXISTS customer_history (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL,
+    customer_number VARCHAR(50) NOT NULL,
+    field VARCHAR(63) NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    run_id VARCHAR(64),
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_history_customer_number ON customer_history(customer_number);
+CREATE INDEX IF NOT EXISTS idx_customer_history_run_id ON customer_history(run_id);
+
+CREATE TABLE IF NOT EXISTS account_history (
+    id BIGSERIAL PRIMARY KEY,
+    account_id INTEGER NOT NULL,
+    account_number VARCHAR(50) NOT NULL,
+    field VARCHAR(63) NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    run_id VARCHAR(64),
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_history_account_number ON account_history(account_number);
+CREATE INDEX IF NOT EXISTS idx_account_history_run_id ON account_history(run_id);
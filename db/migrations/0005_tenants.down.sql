@@ -0,0 +1,13 @@
+-- Fails if numbers collide across tenants, which must be resolved by hand
+DROP INDEX IF EXISTS idx_account_history_tenant;
+DROP INDEX IF EXISTS idx_customer_history_tenant;
+ALTER TABLE account_history DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE customer_history DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_tenant_account_number_key;
+ALTER TABLE accounts ADD CONSTRAINT accounts_account_number_key UNIQUE (account_number);
+ALTER TABLE accounts DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE customers DROP CONSTRAINT IF EXISTS customers_tenant_customer_number_key;
+ALTER TABLE customers ADD CONSTRAINT customers_customer_number_key UNIQUE (customer_number);
+ALTER TABLE customers DROP COLUMN IF EXISTS tenant_id;
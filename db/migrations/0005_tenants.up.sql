@@ -0,0 +1,15 @@
+-- Scope customer and account numbers by tenant. Rows imported outside
+-- tenant mode keep the empty tenant, so existing uniqueness is unchanged.
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE customers DROP CONSTRAINT IF EXISTS customers_customer_number_key;
+ALTER TABLE customers ADD CONSTRAINT customers_tenant_customer_number_key UNIQUE (tenant_id, customer_number);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_account_number_key;
+ALTER TABLE accounts ADD CONSTRAINT accounts_tenant_account_number_key UNIQUE (tenant_id, account_number);
+
+ALTER TABLE customer_history ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE account_history ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_customer_history_tenant ON customer_history(tenant_id, customer_number);
+CREATE INDEX IF NOT EXISTS idx_account_history_tenant ON account_history(tenant_id, account_number);
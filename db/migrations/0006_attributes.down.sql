@@ -0,0 +1,3 @@
+ALTER TABLE customer_accounts DROP COLUMN IF EXISTS attributes;
+ALTER TABLE accounts DROP COLUMN IF EXISTS attributes;
+ALTER TABLE customers DROP COLUMN IF EXISTS attributes;
@@ -0,0 +1,4 @@
+-- Extra sheet columns kept as attributes
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE customer_accounts ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
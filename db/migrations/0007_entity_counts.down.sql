@@ -0,0 +1 @@
+ALTER TABLE import_runs DROP COLUMN IF EXISTS entity_counts;
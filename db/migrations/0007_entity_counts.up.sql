@@ -0,0 +1,2 @@
+-- Rows written per entity by imports driven by entity definitions
+ALTER TABLE import_runs ADD COLUMN IF NOT EXISTS entity_counts JSONB;
@@ -2,41 +2,121 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"sync"
+	"time"
 
 	"importer/config"
 	"importer/models"
 )
 
 type PostgresDB struct {
-	db  *sql.DB
-	cfg *config.AppConfig
+	db    *sql.DB
+	cfg   *config.AppConfig
+	stmts *statements    // Generated from the configured mapping
+	audit bool           // Whether runs, changes and history are logged
+	runID sql.NullString // Stamped on every row written, set by BeginRun
+
+	entityUpserts map[string]upsert // Set by PrepareEntities
 }
 
 func NewPostgresDB(cfg *config.AppConfig) (*PostgresDB, error) {
+	mapping, err := newSchemaMap(cfg.DB.Mapping, cfg.TenantMode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid table mapping: %v", err)
+	}
+
+	db, err := open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail before the workbook is read if the target tables are not usable.
+	// Without the audit tables the target need not be migrated at all.
+	if mapping.audit {
+		if err := checkSchemaVersion(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	} else {
+		log.Printf("Audit disabled by db.mapping.skip_audit: runs are not logged and cannot be rolled back")
+	}
+	if err := checkSchema(db, mapping); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresDB{
+		db:    db,
+		cfg:   cfg,
+		stmts: newStatements(mapping),
+		audit: mapping.audit,
+	}, nil
+}
+
+// open connects to the database with the configured pool settings
+func open(cfg *config.AppConfig) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.DB.ConnectionString())
 	if err != nil {
 		return nil, err
 	}
 
+	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime)
+
 	// Test connection
-	if err := db.Ping(); err != nil {
+	if err := pingWithRetry(db, cfg.DB.ConnectRetries, cfg.DB.ConnectBackoff); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %v", err)
 	}
+	return db, nil
+}
 
-	return &PostgresDB{
-		db:  db,
-		cfg: cfg,
-	}, nil
+// pingWithRetry pings the database, retrying with exponential backoff so the
+// importer can start alongside a database that is still booting
+func pingWithRetry(db *sql.DB, retries int, backoff time.Duration) error {
+	const maxBackoff = 30 * time.Second
+
+	for attempt := 0; ; attempt++ {
+		err := db.Ping()
+		if err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			return err
+		}
+
+		log.Printf("Database not ready (attempt %d/%d): %v, retrying in %v", attempt+1, retries+1, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
 }
 
+// errNoAudit is returned for audit records when db.mapping.skip_audit is set
+var errNoAudit = errors.New("the audit tables are disabled by db.mapping.skip_audit")
+
 var _ models.CustomerRepository = (*PostgresDB)(nil)
+var _ models.Exporter = (*PostgresDB)(nil)
+var _ models.ParallelLoader = (*PostgresDB)(nil)
 
 func (p *PostgresDB) Close() error {
 	return p.db.Close()
 }
 
+// SupportsParallelLoad reports that customers and accounts can be inserted
+// concurrently; each insert runs on its own pooled connection
+func (p *PostgresDB) SupportsParallelLoad() bool {
+	return true
+}
+
 // CustomerRepository implementation
 func (p *PostgresDB) InsertCustomers(customers []models.Customer) (map[string]int, error) {
 	customerIDs := make(map[string]int)
@@ -45,17 +125,7 @@ func (p *PostgresDB) InsertCustomers(customers []models.Customer) (map[string]in
 		return nil, fmt.Errorf("failed to begin transaction: %v", err)
 	}
 
-	stmt, err := tx.Prepare(`
-        INSERT INTO customers (client_id, customer_number, customer_name, address, name, email)
-        VALUES ($1, $2, $3, $4, $5, $6)
-        ON CONFLICT (customer_number) DO UPDATE SET
-            client_id = EXCLUDED.client_id,
-            customer_name = EXCLUDED.customer_name,
-            address = EXCLUDED.address,
-            name = EXCLUDED.name,
-            email = EXCLUDED.email,
-            updated_at = CURRENT_TIMESTAMP
-        RETURNING id, customer_number`)
+	stmt, err := tx.Prepare(p.stmts.upsertCustomer.sql)
 	if err != nil {
 		tx.Rollback()
 		return nil, fmt.Errorf("failed to prepare statement: %v", err)
@@ -64,21 +134,24 @@ func (p *PostgresDB) InsertCustomers(customers []models.Customer) (map[string]in
 
 	for i, customer := range customers {
 		var id int
-		err = stmt.QueryRow(
-			customer.ClientID,
-			customer.CustomerNumber,
-			customer.CustomerName,
-			customer.Address,
-			customer.Name,
-			customer.Email,
-		).Scan(&id, &customer.CustomerNumber)
+		err = stmt.QueryRow(p.stmts.upsertCustomer.args(map[string]interface{}{
+			"client_id":       customer.ClientID,
+			"customer_number": customer.CustomerNumber,
+			"customer_name":   customer.CustomerName,
+			"address":         customer.Address,
+			"name":            customer.Name,
+			"email":           customer.Email,
+			"attributes":      encodeAttributes(customer.Attributes),
+			"tenant_id":       customer.Tenant,
+			"run_id":          p.runID,
+		})...).Scan(&id)
 
 		if err != nil {
 			tx.Rollback()
 			return nil, fmt.Errorf("failed to insert customer %s: %v", customer.CustomerNumber, err)
 		}
 
-		customerIDs[customer.CustomerNumber] = id
+		customerIDs[customer.Key()] = id
 
 		if (i+1)%p.cfg.BatchSize == 0 {
 			if err := tx.Commit(); err != nil {
@@ -88,17 +161,7 @@ func (p *PostgresDB) InsertCustomers(customers []models.Customer) (map[string]in
 			if err != nil {
 				return nil, fmt.Errorf("failed to begin new transaction: %v", err)
 			}
-			stmt, err = tx.Prepare(`
-                INSERT INTO customers (client_id, customer_number, customer_name, address, name, email)
-                VALUES ($1, $2, $3, $4, $5, $6)
-                ON CONFLICT (customer_number) DO UPDATE SET
-                    client_id = EXCLUDED.client_id,
-                    customer_name = EXCLUDED.customer_name,
-                    address = EXCLUDED.address,
-                    name = EXCLUDED.name,
-                    email = EXCLUDED.email,
-                    updated_at = CURRENT_TIMESTAMP
-                RETURNING id, customer_number`)
+			stmt, err = tx.Prepare(p.stmts.upsertCustomer.sql)
 			if err != nil {
 				tx.Rollback()
 				return nil, fmt.Errorf("failed to prepare statement: %v", err)
@@ -122,13 +185,7 @@ func (p *PostgresDB) InsertAccounts(accounts []models.Account) (map[string]int,
 		return nil, fmt.Errorf("failed to begin transaction: %v", err)
 	}
 
-	stmt, err := tx.Prepare(`
-        INSERT INTO accounts (account_number, account_name)
-        VALUES ($1, $2)
-        ON CONFLICT (account_number) DO UPDATE SET
-            account_name = EXCLUDED.account_name,
-            updated_at = CURRENT_TIMESTAMP
-        RETURNING id, account_number`)
+	stmt, err := tx.Prepare(p.stmts.upsertAccount.sql)
 	if err != nil {
 		tx.Rollback()
 		return nil, fmt.Errorf("failed to prepare statement: %v", err)
@@ -137,17 +194,20 @@ func (p *PostgresDB) InsertAccounts(accounts []models.Account) (map[string]int,
 
 	for i, account := range accounts {
 		var id int
-		err = stmt.QueryRow(
-			account.AccountNumber,
-			account.AccountName,
-		).Scan(&id, &account.AccountNumber)
+		err = stmt.QueryRow(p.stmts.upsertAccount.args(map[string]interface{}{
+			"account_number": account.AccountNumber,
+			"account_name":   account.AccountName,
+			"attributes":     encodeAttributes(account.Attributes),
+			"tenant_id":      account.Tenant,
+			"run_id":         p.runID,
+		})...).Scan(&id)
 
 		if err != nil {
 			tx.Rollback()
 			return nil, fmt.Errorf("failed to insert account %s: %v", account.AccountNumber, err)
 		}
 
-		accountIDs[account.AccountNumber] = id
+		accountIDs[account.Key()] = id
 
 		if (i+1)%p.cfg.BatchSize == 0 {
 			if err := tx.Commit(); err != nil {
@@ -157,13 +217,7 @@ func (p *PostgresDB) InsertAccounts(accounts []models.Account) (map[string]int,
 			if err != nil {
 				return nil, fmt.Errorf("failed to begin new transaction: %v", err)
 			}
-			stmt, err = tx.Prepare(`
-                INSERT INTO accounts (account_number, account_name)
-                VALUES ($1, $2)
-                ON CONFLICT (account_number) DO UPDATE SET
-                    account_name = EXCLUDED.account_name,
-                    updated_at = CURRENT_TIMESTAMP
-                RETURNING id, account_number`)
+			stmt, err = tx.Prepare(p.stmts.upsertAccount.sql)
 			if err != nil {
 				tx.Rollback()
 				return nil, fmt.Errorf("failed to prepare statement: %v", err)
@@ -179,17 +233,89 @@ func (p *PostgresDB) InsertAccounts(accounts []models.Account) (map[string]int,
 	return accountIDs, nil
 }
 
+// resolvedLink is a customer-account link whose IDs are known
+type resolvedLink struct {
+	models.CustomerAccount
+	customerID int
+	accountID  int
+}
+
 // CustomerAccountRepository implementation
-func (p *PostgresDB) InsertCustomerAccounts(links []models.CustomerAccount, customerIDs, accountIDs map[string]int) error {
+func (p *PostgresDB) InsertCustomerAccounts(links []models.CustomerAccount, customerIDs, accountIDs map[string]int) (int, error) {
+	resolved := make([]resolvedLink, 0, len(links))
+	for _, link := range links {
+		customerID, ok := customerIDs[link.CustomerKey()]
+		if !ok {
+			log.Printf("Warning: Customer number %s not found", link.CustomerKey())
+			continue
+		}
+
+		accountID, ok := accountIDs[link.AccountKey()]
+		if !ok {
+			log.Printf("Warning: Account number %s not found", link.AccountKey())
+			continue
+		}
+
+		resolved = append(resolved, resolvedLink{CustomerAccount: link, customerID: customerID, accountID: accountID})
+	}
+
+	// Partition by customer ID range so no two workers touch the same
+	// customer's rows of the unique index
+	sort.Slice(resolved, func(i, j int) bool { return resolved[i].customerID < resolved[j].customerID })
+	partitions := partitionLinks(resolved, p.cfg.DB.LinkWorkers)
+
+	errs := make([]error, len(partitions))
+	var wg sync.WaitGroup
+	for i, part := range partitions {
+		wg.Add(1)
+		go func(i int, part []resolvedLink) {
+			defer wg.Done()
+			errs[i] = p.insertLinkPartition(part)
+		}(i, part)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return 0, err
+	}
+	return len(resolved), nil
+}
+
+// partitionLinks splits links sorted by customer ID into at most n
+// contiguous ranges, never splitting one customer across two ranges
+func partitionLinks(links []resolvedLink, n int) [][]resolvedLink {
+	if n < 1 {
+		n = 1
+	}
+	size := (len(links) + n - 1) / n
+
+	var partitions [][]resolvedLink
+	for start := 0; start < len(links); {
+		end := start + size
+		if end >= len(links) {
+			end = len(links)
+		} else {
+			for end < len(links) && links[end].customerID == links[end-1].customerID {
+				end++
+			}
+		}
+		partitions = append(partitions, links[start:end])
+		start = end
+	}
+	return partitions
+}
+
+func (p *PostgresDB) insertLinkPartition(links []resolvedLink) error {
+	if len(links) == 0 {
+		return nil
+	}
+
 	tx, err := p.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
 
-	stmt, err := tx.Prepare(`
-        INSERT INTO customer_accounts (customer_id, account_id)
-        VALUES ($1, $2)
-        ON CONFLICT (customer_id, account_id) DO NOTHING`)
+	stmt, err := tx.Prepare(p.stmts.insertLink.sql)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to prepare statement: %v", err)
@@ -197,19 +323,12 @@ func (p *PostgresDB) InsertCustomerAccounts(links []models.CustomerAccount, cust
 	defer stmt.Close()
 
 	for i, link := range links {
-		customerID, ok := customerIDs[link.CustomerNumber]
-		if !ok {
-			log.Printf("Warning: Customer number %s not found", link.CustomerNumber)
-			continue
-		}
-
-		accountID, ok := accountIDs[link.AccountNumber]
-		if !ok {
-			log.Printf("Warning: Account number %s not found", link.AccountNumber)
-			continue
-		}
-
-		_, err = stmt.Exec(customerID, accountID)
+		_, err = stmt.Exec(p.stmts.insertLink.args(map[string]interface{}{
+			"customer_id": link.customerID,
+			"account_id":  link.accountID,
+			"attributes":  encodeAttributes(link.Attributes),
+			"run_id":      p.runID,
+		})...)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to insert customer-account link %s-%s: %v",
@@ -224,15 +343,13 @@ func (p *PostgresDB) InsertCustomerAccounts(links []models.CustomerAccount, cust
 			if err != nil {
 				return fmt.Errorf("failed to begin new transaction: %v", err)
 			}
-			stmt, err = tx.Prepare(`
-                INSERT INTO customer_accounts (customer_id, account_id)
-                VALUES ($1, $2)
-                ON CONFLICT (customer_id, account_id) DO NOTHING`)
+			stmt, err = tx.Prepare(p.stmts.insertLink.sql)
 			if err != nil {
 				tx.Rollback()
 				return fmt.Errorf("failed to prepare statement: %v", err)
 			}
-			log.Printf("Processed %d customer-account links", i+1)
+			log.Printf("Processed %d customer-account links for customers %d-%d",
+				i+1, links[0].customerID, links[len(links)-1].customerID)
 		}
 	}
 
@@ -242,3 +359,110 @@ func (p *PostgresDB) InsertCustomerAccounts(links []models.CustomerAccount, cust
 
 	return nil
 }
+
+// Exporter implementation
+func (p *PostgresDB) ExportWorkbook() (*models.Workbook, error) {
+	wb := &models.Workbook{}
+	var attrs string
+
+	rows, err := p.db.Query(p.stmts.exportCustomers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query customers: %v", err)
+	}
+	for rows.Next() {
+		var c models.Customer
+		if err := rows.Scan(&c.ClientID, &c.CustomerNumber, &c.CustomerName, &c.Address, &c.Name, &c.Email, &c.Tenant, &attrs); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan customer: %v", err)
+		}
+		if c.Attributes, err = decodeAttributes(attrs); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("customer %s: %v", c.CustomerNumber, err)
+		}
+		wb.Customers = append(wb.Customers, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read customers: %v", err)
+	}
+
+	rows, err = p.db.Query(p.stmts.exportAccounts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query accounts: %v", err)
+	}
+	for rows.Next() {
+		var a models.Account
+		if err := rows.Scan(&a.AccountNumber, &a.AccountName, &a.Tenant, &attrs); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan account: %v", err)
+		}
+		if a.Attributes, err = decodeAttributes(attrs); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("account %s: %v", a.AccountNumber, err)
+		}
+		a.ClientID = a.Tenant
+		wb.Accounts = append(wb.Accounts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read accounts: %v", err)
+	}
+
+	rows, err = p.db.Query(p.stmts.exportLinks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query customer-account links: %v", err)
+	}
+	for rows.Next() {
+		var l models.CustomerAccount
+		if err := rows.Scan(&l.CustomerNumber, &l.AccountNumber, &l.Tenant, &attrs); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan customer-account link: %v", err)
+		}
+		if l.Attributes, err = decodeAttributes(attrs); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("link %s-%s: %v", l.CustomerNumber, l.AccountNumber, err)
+		}
+		l.ClientID = l.Tenant
+		wb.Links = append(wb.Links, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read customer-account links: %v", err)
+	}
+
+	return wb, nil
+}
+
+// encodeAttributes returns attributes as a JSON object for a JSONB column
+func encodeAttributes(attrs map[string]string) string {
+	if len(attrs) == 0 {
+		return "{}"
+	}
+	data, _ := json.Marshal(attrs) // A map of strings always marshals
+	return string(data)
+}
+
+// decodeAttributes parses an attributes column, which may hold non-string
+// values written by other systems
+func decodeAttributes(data string) (map[string]string, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, fmt.Errorf("attributes are not a JSON object: %v", err)
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	attrs := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			attrs[k] = s
+		} else {
+			b, _ := json.Marshal(v)
+			attrs[k] = string(b)
+		}
+	}
+	return attrs, nil
+}
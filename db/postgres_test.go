@@ -0,0 +1,62 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+// links builds sorted resolved links, one per customer ID given
+func links(customerIDs ...int) []resolvedLink {
+	out := make([]resolvedLink, len(customerIDs))
+	for i, id := range customerIDs {
+		out[i] = resolvedLink{customerID: id, accountID: i + 1}
+	}
+	return out
+}
+
+func TestPartitionLinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		links []resolvedLink
+		n     int
+		want  [][]int // Customer IDs per partition
+	}{
+		{name: "empty", links: nil, n: 4, want: nil},
+		{name: "even", links: links(1, 2, 3, 4), n: 2, want: [][]int{{1, 2}, {3, 4}}},
+		{name: "uneven", links: links(1, 2, 3, 4, 5), n: 2, want: [][]int{{1, 2, 3}, {4, 5}}},
+		{name: "more workers than links", links: links(1, 2, 3), n: 8, want: [][]int{{1}, {2}, {3}}},
+		{name: "zero workers", links: links(1, 2, 3), n: 0, want: [][]int{{1, 2, 3}}},
+		{name: "negative workers", links: links(1, 2, 3), n: -2, want: [][]int{{1, 2, 3}}},
+		{name: "customer spans a boundary", links: links(1, 2, 2, 3), n: 2, want: [][]int{{1, 2, 2}, {3}}},
+		{
+			name:  "customer larger than a partition",
+			links: links(1, 2, 2, 2, 2, 2, 3, 4),
+			n:     4,
+			want:  [][]int{{1, 2, 2, 2, 2, 2}, {3, 4}},
+		},
+		{name: "single customer", links: links(7, 7, 7, 7), n: 3, want: [][]int{{7, 7, 7, 7}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]int
+			total := 0
+			for _, part := range partitionLinks(tt.links, tt.n) {
+				ids := make([]int, len(part))
+				for i, l := range part {
+					ids[i] = l.customerID
+				}
+				got = append(got, ids)
+				total += len(part)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if total != len(tt.links) {
+				t.Errorf("partitions hold %d links, want %d", total, len(tt.links))
+			}
+			if n := tt.n; n > 0 && len(got) > n {
+				t.Errorf("%d partitions, want at most %d", len(got), n)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"fmt"
+
+	"importer/models"
+
+	"github.com/lib/pq"
+)
+
+var _ models.IDResolver = (*PostgresDB)(nil)
+
+// lookupBatch bounds the keys sent in one lookup query
+const lookupBatch = 5000
+
+// ResolveCustomerIDs looks up the IDs of existing customers in batches
+func (p *PostgresDB) ResolveCustomerIDs(keys []models.NaturalKey) (map[string]int, error) {
+	ids, err := p.resolve(p.stmts.lookupCustomers, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up customers: %v", err)
+	}
+	return ids, nil
+}
+
+// ResolveAccountIDs looks up the IDs of existing accounts in batches
+func (p *PostgresDB) ResolveAccountIDs(keys []models.NaturalKey) (map[string]int, error) {
+	ids, err := p.resolve(p.stmts.lookupAccounts, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up accounts: %v", err)
+	}
+	return ids, nil
+}
+
+func (p *PostgresDB) resolve(query string, keys []models.NaturalKey) (map[string]int, error) {
+	ids := make(map[string]int)
+	for start := 0; start < len(keys); start += lookupBatch {
+		batch := keys[start:min(start+lookupBatch, len(keys))]
+		tenants := make([]string, len(batch))
+		numbers := make([]string, len(batch))
+		for i, k := range batch {
+			tenants[i], numbers[i] = k.Tenant, k.Number
+		}
+
+		rows, err := p.db.Query(query, pq.Array(tenants), pq.Array(numbers))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			var k models.NaturalKey
+			if err := rows.Scan(&id, &k.Tenant, &k.Number); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ids[k.Key()] = id
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"importer/models"
+)
+
+// RollbackResult counts the rows reverted by a rollback
+type RollbackResult struct {
+	Deleted  int
+	Restored int
+	Missing  int // Rows already deleted since the run
+}
+
+// RunConflict is a row changed by the run being rolled back and again by a
+// later run, or a link a later run made to a row the run inserted
+type RunConflict struct {
+	Table    string
+	RowID    int
+	LaterRun string
+}
+
+// RunConflictError is returned when later runs touched rows of the run
+type RunConflictError struct {
+	RunID     string
+	Conflicts []RunConflict
+}
+
+func (e *RunConflictError) Error() string {
+	runs := make(map[string]bool)
+	for _, c := range e.Conflicts {
+		runs[c.LaterRun] = true
+	}
+	return fmt.Sprintf("%d rows changed by run %s were changed again or linked by %d later runs, use -force to roll back anyway",
+		len(e.Conflicts), e.RunID, len(runs))
+}
+
+// RollbackRun reverts the changes logged for runID in a single transaction:
+// rows it inserted are deleted, rows it updated get their before-images back
+// and its field history is removed. It refuses when later runs changed the
+// same rows, or linked customers and accounts it inserted (deleting those
+// removes the links), unless force is set, in which case those later changes
+// are overwritten too.
+func (p *PostgresDB) RollbackRun(runID string, force bool) (*RollbackResult, error) {
+	if !p.audit {
+		return nil, errNoAudit
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the run before checking its status, so a concurrent rollback
+	// waits and then sees it rolled back
+	var status string
+	err = tx.QueryRow(`SELECT status FROM import_runs WHERE run_id = $1 FOR UPDATE`, runID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("import run %s not found", runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up import run: %v", err)
+	}
+	switch status {
+	case models.RunRunning:
+		return nil, fmt.Errorf("import run %s is still running", runID)
+	case models.RunRolledBack:
+		return nil, fmt.Errorf("import run %s has already been rolled back", runID)
+	}
+
+	conflicts, err := laterChanges(tx, runID)
+	if err != nil {
+		return nil, err
+	}
+	linked, err := p.laterLinks(tx, runID)
+	if err != nil {
+		return nil, err
+	}
+	conflicts = append(conflicts, linked...)
+	if len(conflicts) > 0 {
+		if !force {
+			return nil, &RunConflictError{RunID: runID, Conflicts: conflicts}
+		}
+		log.Printf("Warning: overwriting %d rows changed by later runs", len(conflicts))
+	}
+
+	changes, err := p.runChanges(tx, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RollbackResult{}
+	for _, c := range changes {
+		var res sql.Result
+		switch {
+		case isEntityTable(c.table):
+			res, err = revertEntityChange(tx, c)
+		case c.operation == "insert":
+			res, err = tx.Exec(p.stmts.remove[c.table], c.rowID)
+		default:
+			if p.stmts.restore[c.table] == "" {
+				return nil, fmt.Errorf("cannot restore %s row %d, the mapping writes no columns to update", c.table, c.rowID)
+			}
+			res, err = tx.Exec(p.stmts.restore[c.table], c.rowID, c.before.String)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to revert %s row %d: %v", c.table, c.rowID, err)
+		}
+
+		// Deleting a customer or account also removes its links, so a row
+		// can already be gone by the time its own change is reverted
+		if n, _ := res.RowsAffected(); n == 0 {
+			result.Missing++
+		} else if c.operation == "insert" {
+			result.Deleted++
+		} else {
+			result.Restored++
+		}
+	}
+
+	// The reverted field changes never happened as far as the history is concerned
+	for _, table := range []string{"customer_history", "account_history"} {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE run_id = $1`, table), runID); err != nil {
+			return nil, fmt.Errorf("failed to remove %s of the run: %v", table, err)
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE import_runs SET status = $2 WHERE run_id = $1`, runID, models.RunRolledBack); err != nil {
+		return nil, fmt.Errorf("failed to mark import run rolled back: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit rollback: %v", err)
+	}
+	return result, nil
+}
+
+type change struct {
+	table     string
+	rowID     int
+	operation string
+	before    sql.NullString // JSON, as a string so it is sent as jsonb rather than bytea
+}
+
+// runChanges returns the changes of a run, newest first, so repeated changes
+// to one row unwind back to its state before the run
+func (p *PostgresDB) runChanges(tx *sql.Tx, runID string) ([]change, error) {
+	rows, err := tx.Query(`
+        SELECT table_name, row_id, operation, before_image
+        FROM import_changes
+        WHERE run_id = $1
+        ORDER BY id DESC`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change log: %v", err)
+	}
+	defer rows.Close()
+
+	var changes []change
+	for rows.Next() {
+		var c change
+		if err := rows.Scan(&c.table, &c.rowID, &c.operation, &c.before); err != nil {
+			return nil, fmt.Errorf("failed to read change log: %v", err)
+		}
+		if _, ok := p.stmts.remove[c.table]; !ok && !isEntityTable(c.table) {
+			return nil, fmt.Errorf("change log references unknown table %q", c.table)
+		}
+		if c.operation != "insert" && c.operation != "update" {
+			return nil, fmt.Errorf("change log has unknown operation %q", c.operation)
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// laterChanges finds rows of the run that a later, not rolled back run also
+// changed. Tables of entity definitions are logged quoted, so "customers"
+// and customers are the same table.
+func laterChanges(tx *sql.Tx, runID string) ([]RunConflict, error) {
+	rows, err := tx.Query(`
+        SELECT DISTINCT c.table_name, c.row_id, later.run_id
+        FROM import_changes c
+        JOIN import_changes later
+          ON btrim(later.table_name, '"') = btrim(c.table_name, '"')
+         AND later.row_id = c.row_id
+         AND later.id > c.id
+         AND later.run_id <> c.run_id
+        JOIN import_runs r ON r.run_id = later.run_id
+        WHERE c.run_id = $1 AND r.status <> $2
+        ORDER BY c.table_name, c.row_id`, runID, models.RunRolledBack)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check later runs: %v", err)
+	}
+	defer rows.Close()
+
+	var conflicts []RunConflict
+	for rows.Next() {
+		var c RunConflict
+		if err := rows.Scan(&c.Table, &c.RowID, &c.LaterRun); err != nil {
+			return nil, fmt.Errorf("failed to check later runs: %v", err)
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, rows.Err()
+}
+
+// laterLinks finds links written by a later, not rolled back run to
+// customers or accounts the run inserted. Deleting those rows cascades to
+// the links.
+func (p *PostgresDB) laterLinks(tx *sql.Tx, runID string) ([]RunConflict, error) {
+	rows, err := tx.Query(p.stmts.laterLinks, runID, models.RunRolledBack)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check later links: %v", err)
+	}
+	defer rows.Close()
+
+	var conflicts []RunConflict
+	for rows.Next() {
+		c := RunConflict{Table: linkSpec.entity}
+		if err := rows.Scan(&c.RowID, &c.LaterRun); err != nil {
+			return nil, fmt.Errorf("failed to check later links: %v", err)
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, rows.Err()
+}
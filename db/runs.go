@@ -0,0 +1,120 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"importer/models"
+)
+
+var _ models.RunLedger = (*PostgresDB)(nil)
+
+// maxErrorSummary bounds the error text stored in the ledger
+const maxErrorSummary = 2000
+
+// BeginRun records a running import and stamps subsequent writes with its ID.
+// Without audit only the stamping is done.
+func (p *PostgresDB) BeginRun(run *models.ImportRun) error {
+	run.Backend = "postgres"
+	run.Status = models.RunRunning
+	if !p.audit {
+		p.runID = sql.NullString{String: run.RunID, Valid: true}
+		return nil
+	}
+	_, err := p.db.Exec(`
+        INSERT INTO import_runs (run_id, file_name, file_sha256, operator, backend, started_at, status)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		run.RunID, run.FileName, run.FileSHA256, run.Operator, run.Backend, run.StartedAt, run.Status)
+	if err != nil {
+		return fmt.Errorf("failed to record import run: %v", err)
+	}
+
+	p.runID = sql.NullString{String: run.RunID, Valid: true}
+	return nil
+}
+
+// FinishRun records the outcome and counts of an import run
+func (p *PostgresDB) FinishRun(run *models.ImportRun) error {
+	now := time.Now()
+	run.FinishedAt = &now
+	if !p.audit {
+		return nil
+	}
+	if len(run.ErrorSummary) > maxErrorSummary {
+		// Cut on a rune boundary, Postgres rejects invalid UTF-8
+		n := maxErrorSummary
+		for n > 0 && !utf8.RuneStart(run.ErrorSummary[n]) {
+			n--
+		}
+		run.ErrorSummary = run.ErrorSummary[:n]
+	}
+
+	var counts interface{}
+	if run.EntityCounts != nil {
+		data, err := json.Marshal(run.EntityCounts)
+		if err != nil {
+			return fmt.Errorf("failed to encode entity counts: %v", err)
+		}
+		counts = string(data)
+	}
+
+	_, err := p.db.Exec(`
+        UPDATE import_runs SET
+            finished_at = $2,
+            customer_count = $3,
+            account_count = $4,
+            link_count = $5,
+            status = $6,
+            error_summary = NULLIF($7, ''),
+            entity_counts = $8::JSONB
+        WHERE run_id = $1`,
+		run.RunID, now, run.CustomerCount, run.AccountCount, run.LinkCount, run.Status, run.ErrorSummary, counts)
+	if err != nil {
+		return fmt.Errorf("failed to record import run result: %v", err)
+	}
+	return nil
+}
+
+// ListRuns returns the most recent import runs, newest first
+func (p *PostgresDB) ListRuns(limit int) ([]models.ImportRun, error) {
+	if !p.audit {
+		return nil, errNoAudit
+	}
+	rows, err := p.db.Query(`
+        SELECT run_id, file_name, file_sha256, operator, backend, started_at, finished_at,
+               customer_count, account_count, link_count, status, COALESCE(error_summary, ''),
+               entity_counts
+        FROM import_runs
+        ORDER BY started_at DESC
+        LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query import runs: %v", err)
+	}
+	defer rows.Close()
+
+	var runs []models.ImportRun
+	for rows.Next() {
+		var run models.ImportRun
+		var finishedAt sql.NullTime
+		var counts sql.NullString
+		err := rows.Scan(&run.RunID, &run.FileName, &run.FileSHA256, &run.Operator, &run.Backend,
+			&run.StartedAt, &finishedAt, &run.CustomerCount, &run.AccountCount, &run.LinkCount,
+			&run.Status, &run.ErrorSummary, &counts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan import run: %v", err)
+		}
+		if counts.Valid {
+			if err := json.Unmarshal([]byte(counts.String), &run.EntityCounts); err != nil {
+				return nil, fmt.Errorf("failed to decode entity counts of run %s: %v", run.RunID, err)
+			}
+		}
+		if finishedAt.Valid {
+			run.FinishedAt = &finishedAt.Time
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
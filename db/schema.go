@@ -0,0 +1,136 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// checkSchema verifies that every mapped table exists, has every mapped
+// column according to information_schema, and has the unique constraint the
+// upserts' ON CONFLICT clauses rely on
+func checkSchema(db *sql.DB, mapping *schemaMap) error {
+	var problems []string
+	for _, t := range mapping.tables() {
+		columns := make(map[string]string)
+		for _, col := range t.allColumns() {
+			if t.has(col) {
+				columns[col] = t.columns[col]
+			}
+		}
+		var key []string
+		for _, col := range t.conflictKey() {
+			key = append(key, t.columns[col])
+		}
+
+		problem, err := checkTable(db, t.name(), t.table, columns, key)
+		if err != nil {
+			return err
+		}
+		if problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("database schema is not ready for import: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// checkTable verifies one table, given by its quoted, possibly qualified name
+// and its bare name. columns maps what each column is used for to the column
+// and key lists the columns of the required unique constraint. It returns a
+// description of the problem found, if any.
+func checkTable(db *sql.DB, name, table string, columns map[string]string, key []string) (string, error) {
+	existing, err := tableColumns(db, name, table)
+	if err != nil {
+		return "", err
+	}
+	if existing == nil {
+		return fmt.Sprintf("table %s does not exist", name), nil
+	}
+
+	uses := make([]string, 0, len(columns))
+	for use := range columns {
+		uses = append(uses, use)
+	}
+	sort.Strings(uses)
+	var missing []string
+	for _, use := range uses {
+		column := columns[use]
+		if existing[column] {
+			continue
+		}
+		if column == use {
+			missing = append(missing, column)
+		} else {
+			missing = append(missing, fmt.Sprintf("%s (for %s)", column, use))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Sprintf("table %s has no column %s", name, strings.Join(missing, ", ")), nil
+	}
+
+	key = append([]string(nil), key...)
+	sort.Strings(key)
+
+	var unique bool
+	err = db.QueryRow(`
+            SELECT EXISTS (
+                SELECT 1 FROM pg_index i
+                WHERE i.indrelid = to_regclass($1)
+                  AND i.indisunique
+                  AND i.indpred IS NULL
+                  AND (SELECT array_agg(a.attname::text ORDER BY a.attname::text)
+                       FROM pg_attribute a
+                       WHERE a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)) = $2::text[]
+            )`, name, pq.Array(key)).Scan(&unique)
+	if err != nil {
+		return "", fmt.Errorf("failed to check unique constraints of %s: %v", name, err)
+	}
+	if !unique {
+		return fmt.Sprintf("table %s has no unique constraint on (%s)", name, strings.Join(key, ", ")), nil
+	}
+	return "", nil
+}
+
+// tableColumns returns the columns of a table given by its quoted, possibly
+// qualified name and its bare name, or nil if the table does not exist
+func tableColumns(db *sql.DB, name, table string) (map[string]bool, error) {
+	// Resolve the schema of unqualified tables through the search_path
+	var schema sql.NullString
+	err := db.QueryRow(`
+            SELECT n.nspname FROM pg_class c
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            WHERE c.oid = to_regclass($1)`, name).Scan(&schema)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to check table %s: %v", name, err)
+	}
+	if !schema.Valid {
+		return nil, nil
+	}
+
+	rows, err := db.Query(`
+            SELECT column_name FROM information_schema.columns
+            WHERE table_schema = $1 AND table_name = $2`, schema.String, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %v", name, err)
+	}
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("failed to read columns of %s: %v", name, err)
+		}
+		existing[column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %v", name, err)
+	}
+	return existing, nil
+}
@@ -0,0 +1,229 @@
+package diff
+
+import (
+	"sort"
+	"strings"
+
+	"importer/models"
+)
+
+const (
+	StatusAdded   = "added"
+	StatusRemoved = "removed"
+	StatusChanged = "changed"
+)
+
+// FieldChange is a single field whose value differs between two workbooks
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// RowDiff describes one added, removed or changed row
+type RowDiff struct {
+	Key     string            `json:"key"`
+	Status  string            `json:"status"`
+	Before  map[string]string `json:"before,omitempty"`
+	After   map[string]string `json:"after,omitempty"`
+	Changes []FieldChange     `json:"changes,omitempty"`
+}
+
+// EntityDiff groups the row differences of a single sheet
+type EntityDiff struct {
+	Fields  []string  `json:"-"`
+	Added   []RowDiff `json:"added"`
+	Removed []RowDiff `json:"removed"`
+	Changed []RowDiff `json:"changed"`
+}
+
+// Report is the result of comparing two workbooks
+type Report struct {
+	OldFile   string     `json:"old_file"`
+	NewFile   string     `json:"new_file"`
+	Customers EntityDiff `json:"customers"`
+	Accounts  EntityDiff `json:"accounts"`
+	Links     EntityDiff `json:"links"`
+}
+
+// keyedRow is the comparable form of a row from any sheet
+type keyedRow struct {
+	key    string
+	fields []models.Field
+}
+
+// Compare returns the differences between an old and a new workbook.
+// Customers are keyed by customer number, accounts by account number and
+// links by the customer/account number pair, each scoped by the tenant of
+// the row in tenant mode. Attributes are compared as
+// fields named "attr:" and the attribute, as in manifest hashes.
+func Compare(oldWB, newWB *models.Workbook) *Report {
+	return &Report{
+		Customers: compareRows(customerRows(oldWB.Customers), customerRows(newWB.Customers), customerFieldNames()),
+		Accounts:  compareRows(accountRows(oldWB.Accounts), accountRows(newWB.Accounts), accountFieldNames()),
+		Links:     compareRows(linkRows(oldWB.Links), linkRows(newWB.Links), linkFieldNames()),
+	}
+}
+
+// HasChanges reports whether any sheet differs
+func (r *Report) HasChanges() bool {
+	for _, e := range []EntityDiff{r.Customers, r.Accounts, r.Links} {
+		if len(e.Added) > 0 || len(e.Removed) > 0 || len(e.Changed) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func compareRows(oldRows, newRows []keyedRow, fieldNames []string) EntityDiff {
+	result := EntityDiff{Fields: append(fieldNames, attributeNames(oldRows, newRows)...)}
+
+	oldByKey := make(map[string]keyedRow, len(oldRows))
+	for _, row := range oldRows {
+		oldByKey[row.key] = row
+	}
+	newByKey := make(map[string]keyedRow, len(newRows))
+	for _, row := range newRows {
+		newByKey[row.key] = row
+	}
+
+	for key, newRow := range newByKey {
+		oldRow, ok := oldByKey[key]
+		if !ok {
+			result.Added = append(result.Added, RowDiff{
+				Key:    key,
+				Status: StatusAdded,
+				After:  fieldMap(newRow.fields),
+			})
+			continue
+		}
+
+		changes := changedFields(oldRow.fields, newRow.fields)
+		if len(changes) > 0 {
+			result.Changed = append(result.Changed, RowDiff{
+				Key:     key,
+				Status:  StatusChanged,
+				Before:  fieldMap(oldRow.fields),
+				After:   fieldMap(newRow.fields),
+				Changes: changes,
+			})
+		}
+	}
+
+	for key, oldRow := range oldByKey {
+		if _, ok := newByKey[key]; !ok {
+			result.Removed = append(result.Removed, RowDiff{
+				Key:    key,
+				Status: StatusRemoved,
+				Before: fieldMap(oldRow.fields),
+			})
+		}
+	}
+
+	sortRows(result.Added)
+	sortRows(result.Removed)
+	sortRows(result.Changed)
+	return result
+}
+
+// changedFields compares fields by name, as rows carry different attributes.
+// A field missing on one side is empty there.
+func changedFields(before, after []models.Field) []FieldChange {
+	beforeValues := fieldMap(before)
+	afterValues := fieldMap(after)
+
+	var changes []FieldChange
+	for _, f := range after {
+		if beforeValues[f.Name] != f.Value {
+			changes = append(changes, FieldChange{Field: f.Name, Before: beforeValues[f.Name], After: f.Value})
+		}
+	}
+	for _, f := range before {
+		if _, ok := afterValues[f.Name]; !ok && f.Value != "" {
+			changes = append(changes, FieldChange{Field: f.Name, Before: f.Value})
+		}
+	}
+	return changes
+}
+
+// attributeNames returns the attribute fields found on any row, sorted
+func attributeNames(rowSets ...[]keyedRow) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, rows := range rowSets {
+		for _, row := range rows {
+			for _, f := range row.fields {
+				if strings.HasPrefix(f.Name, models.AttributePrefix) && !seen[f.Name] {
+					seen[f.Name] = true
+					names = append(names, f.Name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func fieldMap(fields []models.Field) map[string]string {
+	m := make(map[string]string, len(fields))
+	for _, f := range fields {
+		m[f.Name] = f.Value
+	}
+	return m
+}
+
+func sortRows(rows []RowDiff) {
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Key < rows[j].Key
+	})
+}
+
+func customerRows(customers []models.Customer) []keyedRow {
+	rows := make([]keyedRow, len(customers))
+	for i, c := range customers {
+		rows[i] = keyedRow{key: c.Key(), fields: append(c.Fields(), models.AttributeFields(c.Attributes)...)}
+	}
+	return rows
+}
+
+func accountRows(accounts []models.Account) []keyedRow {
+	rows := make([]keyedRow, len(accounts))
+	for i, a := range accounts {
+		rows[i] = keyedRow{key: a.Key(), fields: append(a.Fields(), models.AttributeFields(a.Attributes)...)}
+	}
+	return rows
+}
+
+func linkRows(links []models.CustomerAccount) []keyedRow {
+	rows := make([]keyedRow, len(links))
+	for i, l := range links {
+		rows[i] = keyedRow{
+			key: l.Key(),
+			fields: append([]models.Field{
+				{Name: "customer_number", Value: l.CustomerNumber},
+				{Name: "account_number", Value: l.AccountNumber},
+			}, models.AttributeFields(l.Attributes)...),
+		}
+	}
+	return rows
+}
+
+func customerFieldNames() []string {
+	return fieldNames(models.Customer{}.Fields())
+}
+
+func accountFieldNames() []string {
+	return fieldNames(models.Account{}.Fields())
+}
+
+func linkFieldNames() []string {
+	return []string{"customer_number", "account_number"}
+}
+
+func fieldNames(fields []models.Field) []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	return names
+}
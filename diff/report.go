@@ -0,0 +1,112 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Summary holds the per-sheet counts written to the JSON summary
+type Summary struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+}
+
+type jsonReport struct {
+	*Report
+	Summary map[string]Summary `json:"summary"`
+}
+
+func (e EntityDiff) summary() Summary {
+	return Summary{
+		Added:   len(e.Added),
+		Removed: len(e.Removed),
+		Changed: len(e.Changed),
+	}
+}
+
+// WriteJSON writes the report and its per-sheet counts as JSON
+func (r *Report) WriteJSON(filename string) error {
+	out := jsonReport{
+		Report: r,
+		Summary: map[string]Summary{
+			"customers": r.Customers.summary(),
+			"accounts":  r.Accounts.summary(),
+			"links":     r.Links.summary(),
+		},
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal diff report: %v", err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write diff report: %v", err)
+	}
+	return nil
+}
+
+// WriteWorkbook writes a summary sheet plus one sheet per entity listing
+// every added, removed and changed row with its before and after values
+func (r *Report) WriteWorkbook(filename string) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	summarySheet := "Summary"
+	f.SetSheetName("Sheet1", summarySheet)
+	f.SetSheetRow(summarySheet, "A1", &[]interface{}{"Old File", r.OldFile})
+	f.SetSheetRow(summarySheet, "A2", &[]interface{}{"New File", r.NewFile})
+	f.SetSheetRow(summarySheet, "A4", &[]interface{}{"Sheet", "Added", "Removed", "Changed"})
+
+	entities := []struct {
+		sheet string
+		diff  EntityDiff
+	}{
+		{"Customers", r.Customers},
+		{"Accounts", r.Accounts},
+		{"Links", r.Links},
+	}
+
+	for i, e := range entities {
+		s := e.diff.summary()
+		f.SetSheetRow(summarySheet, fmt.Sprintf("A%d", i+5),
+			&[]interface{}{e.sheet, s.Added, s.Removed, s.Changed})
+
+		if err := writeEntitySheet(f, e.sheet, e.diff); err != nil {
+			return err
+		}
+	}
+
+	if err := f.SaveAs(filename); err != nil {
+		return fmt.Errorf("failed to save diff report: %v", err)
+	}
+	return nil
+}
+
+func writeEntitySheet(f *excelize.File, sheet string, e EntityDiff) error {
+	if _, err := f.NewSheet(sheet); err != nil {
+		return fmt.Errorf("failed to create sheet %s: %v", sheet, err)
+	}
+
+	header := []interface{}{"Change", "Key"}
+	for _, name := range e.Fields {
+		header = append(header, name+" (before)", name+" (after)")
+	}
+	f.SetSheetRow(sheet, "A1", &header)
+
+	row := 2
+	for _, rows := range [][]RowDiff{e.Added, e.Removed, e.Changed} {
+		for _, d := range rows {
+			values := []interface{}{d.Status, d.Key}
+			for _, name := range e.Fields {
+				values = append(values, d.Before[name], d.After[name])
+			}
+			f.SetSheetRow(sheet, fmt.Sprintf("A%d", row), &values)
+			row++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Package entity describes importable entities in a definition file so the
+// reader, validator and repositories can handle entities beyond the built-in
+// customers, accounts and links.
+package entity
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed default.yaml
+var defaultDefinition []byte
+
+// Definition is the set of entities imported from one workbook
+type Definition struct {
+	Entities []*Entity `yaml:"entities"`
+
+	byName map[string]*Entity
+}
+
+// Entity is one sheet of the workbook and the table or endpoint it is
+// written to
+type Entity struct {
+	Name       string      `yaml:"name"`
+	Sheet      string      `yaml:"sheet"`
+	Table      string      `yaml:"table"`     // Postgres table, may be schema-qualified
+	IDColumn   string      `yaml:"id_column"` // Defaults to id
+	Endpoint   string      `yaml:"endpoint"`  // API path, e.g. /customers
+	Key        []string    `yaml:"key"`       // Fields forming the natural key
+	Fields     []Field     `yaml:"fields"`
+	References []Reference `yaml:"references"`
+
+	// Scope holds Postgres columns written with the same value on every row,
+	// such as tenant_id. They belong to the table's unique key along with Key.
+	Scope map[string]string `yaml:"scope"`
+
+	// History is customer_history or account_history, to record the changed
+	// fields of every upsert there. The entity needs a single key field.
+	History string `yaml:"history"`
+
+	fieldIndex map[string]int
+}
+
+// Field is a column of the sheet
+type Field struct {
+	Name      string `yaml:"name"`       // Also the JSON name sent to the API
+	Header    string `yaml:"header"`     // Sheet header, defaults to Name
+	Column    string `yaml:"column"`     // Postgres column, defaults to Name
+	Required  bool   `yaml:"required"`   //
+	MaxLength int    `yaml:"max_length"` // In characters, 0 for no limit
+}
+
+// Reference resolves a field holding another entity's key to that entity's
+// ID, written to Column (Postgres) and sent as Column (API) instead of the
+// field itself
+type Reference struct {
+	Field  string `yaml:"field"`
+	Entity string `yaml:"entity"`
+	Column string `yaml:"column"`
+}
+
+// Default returns the definition of the built-in customers, accounts and
+// customer-account links
+func Default() *Definition {
+	def, err := parse(defaultDefinition)
+	if err != nil {
+		panic(fmt.Sprintf("default entity definition: %v", err))
+	}
+	return def
+}
+
+// DefaultYAML returns the default definition file, as a starting point for
+// new definitions
+func DefaultYAML() []byte {
+	return defaultDefinition
+}
+
+// Load reads and checks a definition file
+func Load(filename string) (*Definition, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read definitions: %v", err)
+	}
+	def, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("definitions %s: %v", filename, err)
+	}
+	return def, nil
+}
+
+func parse(data []byte) (*Definition, error) {
+	var def Definition
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&def); err != nil {
+		return nil, err
+	}
+	if err := def.init(); err != nil {
+		return nil, err
+	}
+	return &def, nil
+}
+
+// init fills in defaults and checks that names, keys and references resolve
+func (d *Definition) init() error {
+	if len(d.Entities) == 0 {
+		return fmt.Errorf("no entities defined")
+	}
+
+	d.byName = make(map[string]*Entity, len(d.Entities))
+	for _, e := range d.Entities {
+		if e.Name == "" {
+			return fmt.Errorf("entity without a name")
+		}
+		if d.byName[e.Name] != nil {
+			return fmt.Errorf("entity %s is defined twice", e.Name)
+		}
+		d.byName[e.Name] = e
+
+		if e.Sheet == "" {
+			e.Sheet = e.Name
+		}
+		if e.Table == "" {
+			e.Table = e.Name
+		}
+		if e.IDColumn == "" {
+			e.IDColumn = "id"
+		}
+		if e.Endpoint == "" {
+			e.Endpoint = "/" + e.Name
+		}
+
+		e.fieldIndex = make(map[string]int, len(e.Fields))
+		for i := range e.Fields {
+			f := &e.Fields[i]
+			if f.Name == "" {
+				return fmt.Errorf("entity %s has a field without a name", e.Name)
+			}
+			if _, ok := e.fieldIndex[f.Name]; ok {
+				return fmt.Errorf("entity %s defines field %s twice", e.Name, f.Name)
+			}
+			e.fieldIndex[f.Name] = i
+			if f.Header == "" {
+				f.Header = f.Name
+			}
+			if f.Column == "" {
+				f.Column = f.Name
+			}
+		}
+
+		if len(e.Key) == 0 {
+			return fmt.Errorf("entity %s has no key", e.Name)
+		}
+		for _, k := range e.Key {
+			if _, ok := e.fieldIndex[k]; !ok {
+				return fmt.Errorf("entity %s: key field %s is not defined", e.Name, k)
+			}
+		}
+		if e.History != "" && len(e.Key) != 1 {
+			return fmt.Errorf("entity %s: history needs a single key field", e.Name)
+		}
+	}
+
+	for _, e := range d.Entities {
+		for _, r := range e.References {
+			if _, ok := e.fieldIndex[r.Field]; !ok {
+				return fmt.Errorf("entity %s: reference field %s is not defined", e.Name, r.Field)
+			}
+			target := d.byName[r.Entity]
+			if target == nil {
+				return fmt.Errorf("entity %s: field %s references unknown entity %s", e.Name, r.Field, r.Entity)
+			}
+			if len(target.Key) != 1 {
+				return fmt.Errorf("entity %s: field %s references %s, which has a composite key", e.Name, r.Field, r.Entity)
+			}
+			if r.Column == "" {
+				return fmt.Errorf("entity %s: reference of field %s needs a column", e.Name, r.Field)
+			}
+		}
+	}
+
+	_, err := d.Order()
+	return err
+}
+
+// Entity returns the named entity, or nil
+func (d *Definition) Entity(name string) *Entity {
+	return d.byName[name]
+}
+
+// Order returns the entities so that every entity comes after the entities
+// it references
+func (d *Definition) Order() ([]*Entity, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(d.Entities))
+	var order []*Entity
+
+	var visit func(e *Entity, path []string) error
+	visit = func(e *Entity, path []string) error {
+		switch state[e.Name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("entities reference each other in a cycle: %s", strings.Join(append(path, e.Name), " -> "))
+		}
+		state[e.Name] = visiting
+		for _, r := range e.References {
+			if err := visit(d.byName[r.Entity], append(path, e.Name)); err != nil {
+				return err
+			}
+		}
+		state[e.Name] = done
+		order = append(order, e)
+		return nil
+	}
+
+	for _, e := range d.Entities {
+		if err := visit(e, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Reference returns the reference of a field, if it has one
+func (e *Entity) Reference(field string) (Reference, bool) {
+	for _, r := range e.References {
+		if r.Field == field {
+			return r, true
+		}
+	}
+	return Reference{}, false
+}
+
+// Headers returns the sheet headers of the entity's fields in order
+func (e *Entity) Headers() []string {
+	headers := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		headers[i] = f.Header
+	}
+	return headers
+}
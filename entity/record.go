@@ -0,0 +1,58 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"importer/models"
+)
+
+// Record is one row of an entity's sheet, by field name
+type Record struct {
+	Values map[string]string
+	Row    int
+}
+
+// KeyOf returns the natural key of a record of e
+func (e *Entity) KeyOf(r Record) string {
+	parts := make([]string, len(e.Key))
+	for i, k := range e.Key {
+		parts[i] = r.Values[k]
+	}
+	return strings.Join(parts, "|")
+}
+
+// Validate checks required fields and maximum lengths of every record,
+// returning nil when all are valid
+func (e *Entity) Validate(records []Record) models.ValidationErrors {
+	var errs models.ValidationErrors
+	for _, r := range records {
+		for _, f := range e.Fields {
+			value := r.Values[f.Name]
+			var msg string
+			switch {
+			case f.Required && value == "":
+				msg = "is required"
+			case f.MaxLength > 0 && utf8.RuneCountInString(value) > f.MaxLength:
+				msg = fmt.Sprintf("exceeds %d characters", f.MaxLength)
+			default:
+				continue
+			}
+			errs = append(errs, models.RowError{
+				Sheet:           e.Sheet,
+				Row:             r.Row,
+				ValidationError: models.ValidationError{Field: f.Name, Message: msg},
+			})
+		}
+	}
+	return errs
+}
+
+// Repository writes records of any defined entity. refs holds the IDs of
+// already written entities by entity name and key, used to resolve the
+// references of e; the IDs of the written records are returned by key.
+type Repository interface {
+	PrepareEntities(def *Definition) error
+	UpsertRecords(e *Entity, records []Record, refs map[string]map[string]int) (map[string]int, error)
+}
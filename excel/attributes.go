@@ -0,0 +1,49 @@
+package excel
+
+import (
+	"strings"
+
+	"importer/config"
+	"importer/models"
+)
+
+// keepAttributes drops the attributes of every row whose header is not
+// whitelisted for its sheet
+func keepAttributes(wb *models.Workbook, whitelist config.AttributesConfig) {
+	for i := range wb.Customers {
+		wb.Customers[i].Attributes = filterAttributes(wb.Customers[i].Attributes, whitelist.Customers)
+	}
+	for i := range wb.Accounts {
+		wb.Accounts[i].Attributes = filterAttributes(wb.Accounts[i].Attributes, whitelist.Accounts)
+	}
+	for i := range wb.Links {
+		wb.Links[i].Attributes = filterAttributes(wb.Links[i].Attributes, whitelist.Links)
+	}
+}
+
+func filterAttributes(attrs map[string]string, allowed []string) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	var kept map[string]string
+	for name, value := range attrs {
+		if !allowedHeader(name, allowed) {
+			continue
+		}
+		if kept == nil {
+			kept = make(map[string]string)
+		}
+		kept[name] = value
+	}
+	return kept
+}
+
+func allowedHeader(name string, allowed []string) bool {
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, name) {
+			return true
+		}
+	}
+	return false
+}
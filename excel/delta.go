@@ -0,0 +1,212 @@
+package excel
+
+import (
+	"log"
+
+	"importer/diff"
+	"importer/manifest"
+	"importer/models"
+)
+
+// delta is the subset of a workbook that needs to be sent to the repository,
+// along with target IDs already known for unchanged rows
+type delta struct {
+	wb          *models.Workbook
+	customerIDs map[string]int
+	accountIDs  map[string]int
+}
+
+// deltaFromWorkbook reduces wb to the rows that are new or modified compared
+// to a baseline workbook. A workbook does not record target IDs, so those of
+// unchanged customers and accounts are looked up with resolver, if given.
+func deltaFromWorkbook(baseline, wb *models.Workbook, resolver models.IDResolver) (*delta, error) {
+	report := diff.Compare(baseline, wb)
+	if n := len(report.Customers.Removed) + len(report.Accounts.Removed) + len(report.Links.Removed); n > 0 {
+		log.Printf("Warning: %d rows removed since the baseline are not deleted from the target", n)
+	}
+	customerKeys, accountKeys := rowKeys(report.Customers), rowKeys(report.Accounts)
+
+	var knownCustomers, knownAccounts map[string]int
+	if resolver != nil {
+		var unchangedCustomers, unchangedAccounts []models.NaturalKey
+		for _, c := range wb.Customers {
+			if !customerKeys[c.Key()] {
+				unchangedCustomers = append(unchangedCustomers, models.NaturalKey{Tenant: c.Tenant, Number: c.CustomerNumber})
+			}
+		}
+		for _, a := range wb.Accounts {
+			if !accountKeys[a.Key()] {
+				unchangedAccounts = append(unchangedAccounts, models.NaturalKey{Tenant: a.Tenant, Number: a.AccountNumber})
+			}
+		}
+
+		var err error
+		if len(unchangedCustomers) > 0 {
+			if knownCustomers, err = resolver.ResolveCustomerIDs(unchangedCustomers); err != nil {
+				return nil, err
+			}
+		}
+		if len(unchangedAccounts) > 0 {
+			if knownAccounts, err = resolver.ResolveAccountIDs(unchangedAccounts); err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("Found %d of %d unchanged customers and %d of %d unchanged accounts in the target",
+			len(knownCustomers), len(unchangedCustomers), len(knownAccounts), len(unchangedAccounts))
+	}
+
+	return selectRows(wb, customerKeys, accountKeys, rowKeys(report.Links), knownCustomers, knownAccounts), nil
+}
+
+// deltaFromManifest reduces wb to the rows whose hash differs from, or is
+// missing in, the manifest of a previous run
+func deltaFromManifest(m *manifest.Manifest, wb *models.Workbook) *delta {
+	customerKeys := make(map[string]bool)
+	knownCustomers := make(map[string]int)
+	for _, c := range wb.Customers {
+		if m.Classify(manifest.EntityCustomer, c.Key(), manifest.HashCustomer(c)) != manifest.StatusUnchanged {
+			customerKeys[c.Key()] = true
+		} else if e, _ := m.Lookup(manifest.EntityCustomer, c.Key()); e.TargetID != 0 {
+			knownCustomers[c.Key()] = e.TargetID
+		}
+	}
+
+	accountKeys := make(map[string]bool)
+	knownAccounts := make(map[string]int)
+	for _, a := range wb.Accounts {
+		if m.Classify(manifest.EntityAccount, a.Key(), manifest.HashAccount(a)) != manifest.StatusUnchanged {
+			accountKeys[a.Key()] = true
+		} else if e, _ := m.Lookup(manifest.EntityAccount, a.Key()); e.TargetID != 0 {
+			knownAccounts[a.Key()] = e.TargetID
+		}
+	}
+
+	linkKeys := make(map[string]bool)
+	for _, l := range wb.Links {
+		if m.Classify(manifest.EntityLink, l.Key(), manifest.HashLink(l)) != manifest.StatusUnchanged {
+			linkKeys[l.Key()] = true
+		}
+	}
+
+	return selectRows(wb, customerKeys, accountKeys, linkKeys, knownCustomers, knownAccounts)
+}
+
+// selectRows builds the delta from the keys of new or modified rows, keeping
+// the known target IDs of unchanged customers and accounts. Unchanged
+// customers and accounts referenced by a selected link are kept as well
+// unless their target ID is known, since the link can only be created once
+// their IDs are resolved.
+func selectRows(wb *models.Workbook, customerKeys, accountKeys, linkKeys map[string]bool,
+	knownCustomers, knownAccounts map[string]int) *delta {
+	d := &delta{
+		wb:          &models.Workbook{},
+		customerIDs: make(map[string]int),
+		accountIDs:  make(map[string]int),
+	}
+
+	linkedCustomers := make(map[string]bool)
+	linkedAccounts := make(map[string]bool)
+	for _, link := range wb.Links {
+		if linkKeys[link.Key()] {
+			d.wb.Links = append(d.wb.Links, link)
+			linkedCustomers[link.CustomerKey()] = true
+			linkedAccounts[link.AccountKey()] = true
+		}
+	}
+
+	referenced := 0
+	for _, customer := range wb.Customers {
+		key := customer.Key()
+		switch {
+		case customerKeys[key]:
+			d.wb.Customers = append(d.wb.Customers, customer)
+		case knownCustomers[key] != 0:
+			d.customerIDs[key] = knownCustomers[key]
+		case !linkedCustomers[key]:
+		default:
+			d.wb.Customers = append(d.wb.Customers, customer)
+			referenced++
+		}
+	}
+	for _, account := range wb.Accounts {
+		key := account.Key()
+		switch {
+		case accountKeys[key]:
+			d.wb.Accounts = append(d.wb.Accounts, account)
+		case knownAccounts[key] != 0:
+			d.accountIDs[key] = knownAccounts[key]
+		case !linkedAccounts[key]:
+		default:
+			d.wb.Accounts = append(d.wb.Accounts, account)
+			referenced++
+		}
+	}
+
+	log.Printf("Delta against baseline: %d/%d customers, %d/%d accounts, %d/%d links",
+		len(d.wb.Customers), len(wb.Customers),
+		len(d.wb.Accounts), len(wb.Accounts),
+		len(d.wb.Links), len(wb.Links))
+	if referenced > 0 {
+		log.Printf("Included %d unchanged customers/accounts referenced by new links", referenced)
+	}
+
+	return d
+}
+
+// rowKeys returns the keys of the added and changed rows of an entity
+func rowKeys(e diff.EntityDiff) map[string]bool {
+	keys := make(map[string]bool, len(e.Added)+len(e.Changed))
+	for _, row := range e.Added {
+		keys[row.Key] = true
+	}
+	for _, row := range e.Changed {
+		keys[row.Key] = true
+	}
+	return keys
+}
+
+// buildManifest records every row of the workbook for the current run.
+// Rows that were not sent keep the entry from the previous manifest or, when
+// they were skipped against a baseline workbook, are recorded with the target
+// ID found for them in d, so the manifest always describes the whole file.
+func buildManifest(runID, filename string, wb *models.Workbook, previous *manifest.Manifest, d *delta,
+	customerIDs, accountIDs map[string]int, sentLinks map[string]bool) *manifest.Manifest {
+	m := manifest.New(runID, filename)
+
+	for _, c := range wb.Customers {
+		if id, ok := customerIDs[c.Key()]; ok {
+			m.Add(manifest.Entry{Entity: manifest.EntityCustomer, Key: c.Key(), Hash: manifest.HashCustomer(c), TargetID: id, RunID: runID})
+		} else if e, ok := lookup(previous, manifest.EntityCustomer, c.Key()); ok {
+			m.Add(e)
+		} else {
+			m.Add(manifest.Entry{Entity: manifest.EntityCustomer, Key: c.Key(), Hash: manifest.HashCustomer(c), TargetID: d.customerIDs[c.Key()]})
+		}
+	}
+	for _, a := range wb.Accounts {
+		if id, ok := accountIDs[a.Key()]; ok {
+			m.Add(manifest.Entry{Entity: manifest.EntityAccount, Key: a.Key(), Hash: manifest.HashAccount(a), TargetID: id, RunID: runID})
+		} else if e, ok := lookup(previous, manifest.EntityAccount, a.Key()); ok {
+			m.Add(e)
+		} else {
+			m.Add(manifest.Entry{Entity: manifest.EntityAccount, Key: a.Key(), Hash: manifest.HashAccount(a), TargetID: d.accountIDs[a.Key()]})
+		}
+	}
+	for _, l := range wb.Links {
+		if sentLinks[l.Key()] {
+			m.Add(manifest.Entry{Entity: manifest.EntityLink, Key: l.Key(), Hash: manifest.HashLink(l), RunID: runID})
+		} else if e, ok := lookup(previous, manifest.EntityLink, l.Key()); ok {
+			m.Add(e)
+		} else {
+			m.Add(manifest.Entry{Entity: manifest.EntityLink, Key: l.Key(), Hash: manifest.HashLink(l)})
+		}
+	}
+
+	return m
+}
+
+func lookup(m *manifest.Manifest, entity, key string) (manifest.Entry, bool) {
+	if m == nil {
+		return manifest.Entry{}, false
+	}
+	return m.Lookup(entity, key)
+}
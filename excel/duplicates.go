@@ -0,0 +1,207 @@
+package excel
+
+import (
+	"importer/config"
+	"importer/entity"
+	"importer/models"
+)
+
+// Duplicate is a natural key found on several rows of a sheet
+type Duplicate struct {
+	Sheet string
+	Key   string
+	Rows  []int
+	Kept  int // Row kept, 0 when every row was rejected
+}
+
+// dedupe applies the duplicates policy to every sheet of the workbook,
+// recording each duplicated key in the report
+func dedupe(wb *models.Workbook, policy string, report *RunReport) {
+	customers := wb.Customers
+	keep := resolveDuplicates(models.CustomersSheet, len(customers), policy, report,
+		func(i int) (string, int) {
+			return unlessBlank(customers[i].Key(), customers[i].CustomerNumber), customers[i].Row
+		},
+		func(dst, src int) {
+			d, s := &customers[dst], customers[src]
+			mergeValue(&d.ClientID, s.ClientID)
+			mergeValue(&d.CustomerName, s.CustomerName)
+			mergeValue(&d.Address, s.Address)
+			mergeValue(&d.Name, s.Name)
+			mergeValue(&d.Email, s.Email)
+			d.Attributes = mergeAttributes(d.Attributes, s.Attributes)
+		})
+	wb.Customers = wb.Customers[:0]
+	for _, i := range keep {
+		wb.Customers = append(wb.Customers, customers[i])
+	}
+
+	accounts := wb.Accounts
+	keep = resolveDuplicates(models.AccountsSheet, len(accounts), policy, report,
+		func(i int) (string, int) {
+			return unlessBlank(accounts[i].Key(), accounts[i].AccountNumber), accounts[i].Row
+		},
+		func(dst, src int) {
+			d, s := &accounts[dst], accounts[src]
+			mergeValue(&d.ClientID, s.ClientID)
+			mergeValue(&d.AccountName, s.AccountName)
+			d.Attributes = mergeAttributes(d.Attributes, s.Attributes)
+		})
+	wb.Accounts = wb.Accounts[:0]
+	for _, i := range keep {
+		wb.Accounts = append(wb.Accounts, accounts[i])
+	}
+
+	links := wb.Links
+	keep = resolveDuplicates(models.LinksSheet, len(links), policy, report,
+		func(i int) (string, int) {
+			return unlessBlank(links[i].Key(), links[i].CustomerNumber, links[i].AccountNumber), links[i].Row
+		},
+		func(dst, src int) {
+			d, s := &links[dst], links[src]
+			mergeValue(&d.ClientID, s.ClientID)
+			d.Attributes = mergeAttributes(d.Attributes, s.Attributes)
+		})
+	wb.Links = wb.Links[:0]
+	for _, i := range keep {
+		wb.Links = append(wb.Links, links[i])
+	}
+}
+
+// dedupeRecords applies the duplicates policy to the records of a defined
+// entity
+func dedupeRecords(e *entity.Entity, records []entity.Record, policy string, report *RunReport) []entity.Record {
+	keep := resolveDuplicates(e.Sheet, len(records), policy, report,
+		func(i int) (string, int) {
+			parts := make([]string, len(e.Key))
+			for j, k := range e.Key {
+				parts[j] = records[i].Values[k]
+			}
+			return unlessBlank(e.KeyOf(records[i]), parts...), records[i].Row
+		},
+		func(dst, src int) {
+			for field, value := range records[src].Values {
+				if value != "" {
+					records[dst].Values[field] = value
+				}
+			}
+		})
+	kept := make([]entity.Record, 0, len(keep))
+	for _, i := range keep {
+		kept = append(kept, records[i])
+	}
+	return kept
+}
+
+// resolveDuplicates groups n rows by key and returns the indices of the rows
+// to keep, in sheet order. With the merge policy, merge folds a later row
+// into the first row of its key. Rows with an empty key are kept for
+// validation to reject, not grouped.
+func resolveDuplicates(sheet string, n int, policy string, report *RunReport,
+	key func(i int) (key string, row int), merge func(dst, src int)) []int {
+	groups := make(map[string][]int, n)
+	var order []string
+	blank := 0
+	for i := 0; i < n; i++ {
+		k, _ := key(i)
+		if k == "" {
+			blank++
+			continue
+		}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], i)
+	}
+	if len(order)+blank == n {
+		return allIndices(n)
+	}
+
+	keep := make([]bool, n)
+	for i := 0; i < n; i++ {
+		if k, _ := key(i); k == "" {
+			keep[i] = true
+		}
+	}
+	for _, k := range order {
+		group := groups[k]
+		if len(group) == 1 {
+			keep[group[0]] = true
+			continue
+		}
+
+		kept := -1
+		switch policy {
+		case config.DuplicatesFirst:
+			kept = group[0]
+		case config.DuplicatesMerge:
+			kept = group[0]
+			for _, i := range group[1:] {
+				merge(kept, i)
+			}
+		case config.DuplicatesReject:
+		default:
+			kept = group[len(group)-1]
+		}
+
+		d := Duplicate{Sheet: sheet, Key: k}
+		for _, i := range group {
+			_, row := key(i)
+			d.Rows = append(d.Rows, row)
+			if i == kept {
+				d.Kept = row
+			}
+		}
+		report.duplicate(d)
+		if kept >= 0 {
+			keep[kept] = true
+		}
+	}
+
+	var indices []int
+	for i, ok := range keep {
+		if ok {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+func allIndices(n int) []int {
+	indices := make([]int, n)
+	for i := range indices {
+		indices[i] = i
+	}
+	return indices
+}
+
+// unlessBlank returns key, or "" when one of the values making it up is
+// blank
+func unlessBlank(key string, values ...string) string {
+	for _, v := range values {
+		if v == "" {
+			return ""
+		}
+	}
+	return key
+}
+
+// mergeValue overwrites dst with non-empty values
+func mergeValue(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+func mergeAttributes(dst, src map[string]string) map[string]string {
+	for name, value := range src {
+		if value == "" {
+			continue
+		}
+		if dst == nil {
+			dst = make(map[string]string)
+		}
+		dst[name] = value
+	}
+	return dst
+}
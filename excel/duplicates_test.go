@@ -0,0 +1,140 @@
+package excel
+
+import (
+	"reflect"
+	"testing"
+
+	"importer/config"
+	"importer/models"
+)
+
+func TestResolveDuplicates(t *testing.T) {
+	// Rows 2..8 of a sheet; row 5 has a blank key
+	keys := []string{"A", "B", "A", "", "C", "A", "B"}
+
+	tests := []struct {
+		name       string
+		keys       []string
+		policy     string
+		keep       []int
+		merges     [][2]int
+		duplicates []Duplicate
+	}{
+		{
+			name:   "first",
+			keys:   keys,
+			policy: config.DuplicatesFirst,
+			keep:   []int{0, 1, 3, 4},
+			duplicates: []Duplicate{
+				{Sheet: "Sheet", Key: "A", Rows: []int{2, 4, 7}, Kept: 2},
+				{Sheet: "Sheet", Key: "B", Rows: []int{3, 8}, Kept: 3},
+			},
+		},
+		{
+			name:   "last",
+			keys:   keys,
+			policy: config.DuplicatesLast,
+			keep:   []int{3, 4, 5, 6},
+			duplicates: []Duplicate{
+				{Sheet: "Sheet", Key: "A", Rows: []int{2, 4, 7}, Kept: 7},
+				{Sheet: "Sheet", Key: "B", Rows: []int{3, 8}, Kept: 8},
+			},
+		},
+		{
+			name:   "no policy keeps the last row",
+			keys:   keys,
+			policy: "",
+			keep:   []int{3, 4, 5, 6},
+			duplicates: []Duplicate{
+				{Sheet: "Sheet", Key: "A", Rows: []int{2, 4, 7}, Kept: 7},
+				{Sheet: "Sheet", Key: "B", Rows: []int{3, 8}, Kept: 8},
+			},
+		},
+		{
+			name:   "merge",
+			keys:   keys,
+			policy: config.DuplicatesMerge,
+			keep:   []int{0, 1, 3, 4},
+			merges: [][2]int{{0, 2}, {0, 5}, {1, 6}},
+			duplicates: []Duplicate{
+				{Sheet: "Sheet", Key: "A", Rows: []int{2, 4, 7}, Kept: 2},
+				{Sheet: "Sheet", Key: "B", Rows: []int{3, 8}, Kept: 3},
+			},
+		},
+		{
+			name:   "reject",
+			keys:   keys,
+			policy: config.DuplicatesReject,
+			keep:   []int{3, 4},
+			duplicates: []Duplicate{
+				{Sheet: "Sheet", Key: "A", Rows: []int{2, 4, 7}},
+				{Sheet: "Sheet", Key: "B", Rows: []int{3, 8}},
+			},
+		},
+		{
+			name:   "no duplicates",
+			keys:   []string{"A", "B", "C"},
+			policy: config.DuplicatesReject,
+			keep:   []int{0, 1, 2},
+		},
+		{
+			name:   "blank keys are not duplicates",
+			keys:   []string{"", "A", "", ""},
+			policy: config.DuplicatesReject,
+			keep:   []int{0, 1, 2, 3},
+		},
+		{
+			name:   "blank keys kept beside duplicates",
+			keys:   []string{"", "A", "", "A"},
+			policy: config.DuplicatesReject,
+			keep:   []int{0, 2},
+			duplicates: []Duplicate{
+				{Sheet: "Sheet", Key: "A", Rows: []int{3, 5}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := newRunReport("run", "file.xlsx")
+			var merges [][2]int
+			keep := resolveDuplicates("Sheet", len(tt.keys), tt.policy, report,
+				func(i int) (string, int) { return tt.keys[i], i + 2 },
+				func(dst, src int) { merges = append(merges, [2]int{dst, src}) })
+			if !reflect.DeepEqual(keep, tt.keep) {
+				t.Errorf("kept %v, want %v", keep, tt.keep)
+			}
+			if !reflect.DeepEqual(merges, tt.merges) {
+				t.Errorf("merged %v, want %v", merges, tt.merges)
+			}
+			if !reflect.DeepEqual(report.Duplicates, tt.duplicates) {
+				t.Errorf("reported %+v, want %+v", report.Duplicates, tt.duplicates)
+			}
+		})
+	}
+}
+
+func TestDedupeMerge(t *testing.T) {
+	wb := &models.Workbook{
+		Customers: []models.Customer{
+			{Row: 2, CustomerNumber: "C1", CustomerName: "Jane", Attributes: map[string]string{"tier": "gold"}},
+			{Row: 3, CustomerNumber: "C2", CustomerName: "John"},
+			{Row: 4, CustomerNumber: "C1", Email: "jane@example.com", Attributes: map[string]string{"region": "EU", "tier": ""}},
+			{Row: 5, CustomerName: "No number"},
+		},
+	}
+	report := newRunReport("run", "file.xlsx")
+	dedupe(wb, config.DuplicatesMerge, report)
+
+	want := []models.Customer{
+		{Row: 2, CustomerNumber: "C1", CustomerName: "Jane", Email: "jane@example.com",
+			Attributes: map[string]string{"tier": "gold", "region": "EU"}},
+		{Row: 3, CustomerNumber: "C2", CustomerName: "John"},
+		{Row: 5, CustomerName: "No number"},
+	}
+	if !reflect.DeepEqual(wb.Customers, want) {
+		t.Errorf("got %+v, want %+v", wb.Customers, want)
+	}
+	if len(report.Duplicates) != 1 || report.Duplicates[0].Sheet != models.CustomersSheet {
+		t.Errorf("reported %+v, want one duplicate on %s", report.Duplicates, models.CustomersSheet)
+	}
+}
@@ -1,19 +1,47 @@
 package excel
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"importer/config"
+	"importer/entity"
 	"importer/generator"
+	"importer/manifest"
 	"importer/models"
+	"importer/transform"
 
 	"github.com/xuri/excelize/v2"
 )
 
 type Importer struct {
-	db  models.CustomerRepository
-	cfg interface{}
+	db       models.CustomerRepository
+	cfg      interface{}
+	baseline string
+	manifest string
+	operator string
+
+	tenantMode bool
+	client     string
+	attributes config.AttributesConfig
+	transforms *transform.Rules
+	duplicates string
+
+	orphans     string
+	checkTarget bool
+	only        map[string]bool // Sheets to import, all if empty
+
+	definition *entity.Definition
 }
 
 func NewImporter(db models.CustomerRepository, cfg interface{}) *Importer {
@@ -23,29 +51,122 @@ func NewImporter(db models.CustomerRepository, cfg interface{}) *Importer {
 	}
 }
 
+// SetBaseline restricts the import to rows that are new or modified
+// compared to the given baseline, either a workbook or a manifest (.json)
+// written by a previous run
+func (imp *Importer) SetBaseline(filename string) {
+	imp.baseline = filename
+}
+
+// SetManifest writes a manifest of the imported rows after a successful run
+func (imp *Importer) SetManifest(filename string) {
+	imp.manifest = filename
+}
+
+// SetOperator names the person or system recorded as running the import
+func (imp *Importer) SetOperator(operator string) {
+	imp.operator = operator
+}
+
+// SetTenantMode scopes customer and account numbers by client ID
+func (imp *Importer) SetTenantMode(enabled bool) {
+	imp.tenantMode = enabled
+}
+
+// SetClient restricts a tenant mode import to one client ID. Rows of other
+// clients are rejected.
+func (imp *Importer) SetClient(client string) {
+	imp.client = client
+}
+
+// SetAttributes selects the extra sheet columns kept as attributes
+func (imp *Importer) SetAttributes(whitelist config.AttributesConfig) {
+	imp.attributes = whitelist
+}
+
+// SetTransforms sets the cleanup rules applied to every row before
+// validation
+func (imp *Importer) SetTransforms(rules *transform.Rules) {
+	imp.transforms = rules
+}
+
+// SetDuplicates sets the policy for rows repeating a key within a sheet, one
+// of the config.Duplicates* constants. Without one the last row wins.
+func (imp *Importer) SetDuplicates(policy string) {
+	imp.duplicates = policy
+}
+
+// SetOrphanPolicy sets what happens to links whose customer or account
+// cannot be found, OrphansSkip (the default) or OrphansAbort
+func (imp *Importer) SetOrphanPolicy(policy string) {
+	imp.orphans = policy
+}
+
+// SetCheckTarget also looks for the customers and accounts of links in the
+// target when they are not in the workbook, so links-only and partial
+// workbooks pass the check. The repository must implement models.IDResolver.
+func (imp *Importer) SetCheckTarget(enabled bool) {
+	imp.checkTarget = enabled
+}
+
+// Sheet names accepted by SetOnly
+const (
+	OnlyCustomers = "customers"
+	OnlyAccounts  = "accounts"
+	OnlyLinks     = "links"
+)
+
+// SetOnly restricts the import to some of the sheets, named OnlyCustomers,
+// OnlyAccounts or OnlyLinks. The other sheets are ignored even if present.
+func (imp *Importer) SetOnly(sheets []string) {
+	imp.only = make(map[string]bool, len(sheets))
+	for _, sheet := range sheets {
+		imp.only[sheet] = true
+	}
+}
+
 // GenerateFile creates a new Excel file with generated data
 func GenerateFile(filename string, gen *generator.DataGenerator) error {
+	// Generate the data
+	wb := &models.Workbook{
+		Customers: gen.GenerateCustomers(),
+		Accounts:  gen.GenerateAccounts(),
+		Links:     gen.GenerateLinks(),
+	}
+
+	if err := WriteWorkbook(filename, wb); err != nil {
+		return err
+	}
+
+	// Get and print summary
+	summary := gen.GetSummary()
+	log.Printf("\nGeneration Summary:")
+	log.Printf("------------------")
+	log.Printf("Total Customers: %d", summary.CustomerCount)
+	log.Printf("Total Accounts:  %d", summary.AccountCount)
+	log.Printf("Total Links:     %d", summary.LinkCount)
+	log.Printf("File generated successfully: %s", filename)
+
+	return nil
+}
+
+// WriteWorkbook writes the customers, accounts and links sheets in the layout read by ReadWorkbook
+func WriteWorkbook(filename string, wb *models.Workbook) error {
 	f := excelize.NewFile()
 	defer f.Close()
 
-	// Generate the data
-	customers := gen.GenerateCustomers()
-	accounts := gen.GenerateAccounts()
-	links := gen.GenerateLinks()
-
 	// Create customers sheet
-	customerSheet := "Customers"
+	customerSheet := models.CustomersSheet
 	f.SetSheetName("Sheet1", customerSheet)
 
 	// Set headers for Customers
-	headers := []string{"Client ID", "Customer Number", "Customer Name", "Address", "Name", "Email"}
-	for i, header := range headers {
+	for i, header := range customerColumns {
 		cell := fmt.Sprintf("%c1", 'A'+i)
 		f.SetCellValue(customerSheet, cell, header)
 	}
 
 	// Write customer data
-	for i, customer := range customers {
+	for i, customer := range wb.Customers {
 		row := i + 2
 		f.SetCellValue(customerSheet, fmt.Sprintf("A%d", row), customer.ClientID)
 		f.SetCellValue(customerSheet, fmt.Sprintf("B%d", row), customer.CustomerNumber)
@@ -54,179 +175,653 @@ func GenerateFile(filename string, gen *generator.DataGenerator) error {
 		f.SetCellValue(customerSheet, fmt.Sprintf("E%d", row), customer.Name)
 		f.SetCellValue(customerSheet, fmt.Sprintf("F%d", row), customer.Email)
 	}
+	customerAttrs := make([]map[string]string, len(wb.Customers))
+	for i, customer := range wb.Customers {
+		customerAttrs[i] = customer.Attributes
+	}
+	writeAttributes(f, customerSheet, len(customerColumns), customerAttrs)
 
 	// Create accounts sheet
-	accountSheet := "Account"
+	accountSheet := models.AccountsSheet
 	f.NewSheet(accountSheet)
 
 	// Set headers for Accounts
 	f.SetCellValue(accountSheet, "A1", "Account Number")
 	f.SetCellValue(accountSheet, "B1", "Account Name")
 
+	// Client IDs are only written when the rows are scoped by tenant
+	accountClients := false
+	for _, account := range wb.Accounts {
+		accountClients = accountClients || account.ClientID != ""
+	}
+	if accountClients {
+		f.SetCellValue(accountSheet, "C1", clientIDColumn)
+	}
+
 	// Write account data
-	for i, account := range accounts {
+	for i, account := range wb.Accounts {
 		row := i + 2
 		f.SetCellValue(accountSheet, fmt.Sprintf("A%d", row), account.AccountNumber)
 		f.SetCellValue(accountSheet, fmt.Sprintf("B%d", row), account.AccountName)
+		if accountClients {
+			f.SetCellValue(accountSheet, fmt.Sprintf("C%d", row), account.ClientID)
+		}
 	}
+	accountAttrs := make([]map[string]string, len(wb.Accounts))
+	for i, account := range wb.Accounts {
+		accountAttrs[i] = account.Attributes
+	}
+	writeAttributes(f, accountSheet, len(accountColumns)+boolToInt(accountClients), accountAttrs)
 
 	// Create customer account links sheet
-	linkSheet := "customer account link"
+	linkSheet := models.LinksSheet
 	f.NewSheet(linkSheet)
 
 	// Set headers for Links
 	f.SetCellValue(linkSheet, "A1", "Customer Number")
 	f.SetCellValue(linkSheet, "B1", "Account Number")
 
+	linkClients := false
+	for _, link := range wb.Links {
+		linkClients = linkClients || link.ClientID != ""
+	}
+	if linkClients {
+		f.SetCellValue(linkSheet, "C1", clientIDColumn)
+	}
+
 	// Write link data
-	for i, link := range links {
+	for i, link := range wb.Links {
 		row := i + 2
 		f.SetCellValue(linkSheet, fmt.Sprintf("A%d", row), link.CustomerNumber)
 		f.SetCellValue(linkSheet, fmt.Sprintf("B%d", row), link.AccountNumber)
+		if linkClients {
+			f.SetCellValue(linkSheet, fmt.Sprintf("C%d", row), link.ClientID)
+		}
+	}
+	linkAttrs := make([]map[string]string, len(wb.Links))
+	for i, link := range wb.Links {
+		linkAttrs[i] = link.Attributes
 	}
+	writeAttributes(f, linkSheet, len(linkColumns)+boolToInt(linkClients), linkAttrs)
 
 	// Save the file
 	if err := f.SaveAs(filename); err != nil {
 		return fmt.Errorf("failed to save Excel file: %v", err)
 	}
 
-	// Get and print summary
-	summary := gen.GetSummary()
-	log.Printf("\nGeneration Summary:")
-	log.Printf("------------------")
-	log.Printf("Total Customers: %d", summary.CustomerCount)
-	log.Printf("Total Accounts:  %d", summary.AccountCount)
-	log.Printf("Total Links:     %d", summary.LinkCount)
-	log.Printf("File generated successfully: %s", filename)
-
 	return nil
 }
 
-// Import reads an Excel file and imports the data
-func (imp *Importer) Import(filename string) error {
-	start := time.Now()
+// writeAttributes writes one column per attribute name, sorted, after the
+// first n columns of a sheet. rows holds the attributes of each data row.
+func writeAttributes(f *excelize.File, sheet string, n int, rows []map[string]string) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, attrs := range rows {
+		for name := range attrs {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	for j, name := range names {
+		cell, _ := excelize.CoordinatesToCellName(n+j+1, 1)
+		f.SetCellValue(sheet, cell, name)
+		for i, attrs := range rows {
+			if value, ok := attrs[name]; ok {
+				cell, _ := excelize.CoordinatesToCellName(n+j+1, i+2)
+				f.SetCellValue(sheet, cell, value)
+			}
+		}
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ReadWorkbook reads the customers, accounts and links sheets of an Excel file.
+// Missing sheets are read as empty, but at least one must be present.
+func ReadWorkbook(filename string) (*models.Workbook, error) {
+	return readWorkbook(filename, nil)
+}
+
+// readWorkbook reads the sheets selected in only, or all of them when only is
+// empty. Unselected sheets are not parsed, so their errors do not matter.
+func readWorkbook(filename string, only map[string]bool) (*models.Workbook, error) {
 	f, err := excelize.OpenFile(filename)
 	if err != nil {
-		return fmt.Errorf("failed to open Excel file: %v", err)
+		return nil, fmt.Errorf("failed to open Excel file: %v", err)
 	}
 	defer f.Close()
 
-	// Read all data first
-	customers, err := readCustomers(f)
+	if !hasSheet(f, models.CustomersSheet) && !hasSheet(f, models.AccountsSheet) && !hasSheet(f, models.LinksSheet) {
+		return nil, fmt.Errorf("%s has none of the sheets %q, %q and %q", filename,
+			models.CustomersSheet, models.AccountsSheet, models.LinksSheet)
+	}
+
+	wb := &models.Workbook{}
+	if len(only) == 0 || only[OnlyCustomers] {
+		wb.Customers, err = readCustomers(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read customers: %v", err)
+		}
+		log.Printf("Read %d customers from file", len(wb.Customers))
+	}
+
+	if len(only) == 0 || only[OnlyAccounts] {
+		wb.Accounts, err = readAccounts(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read accounts: %v", err)
+		}
+		log.Printf("Read %d accounts from file", len(wb.Accounts))
+	}
+
+	if len(only) == 0 || only[OnlyLinks] {
+		wb.Links, err = readLinks(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read customer-account links: %v", err)
+		}
+		log.Printf("Read %d links from file", len(wb.Links))
+	}
+
+	return wb, nil
+}
+
+// Import reads an Excel file and imports the data
+func (imp *Importer) Import(filename string) error {
+	report := newRunReport(manifest.NewRunID(), filename)
+	log.Printf("Starting import run %s", report.RunID)
+
+	perform := imp.run
+	if imp.definition != nil {
+		perform = imp.runDefinition
+	}
+
+	ledger, ok := imp.db.(models.RunLedger)
+	if !ok {
+		return perform(report, filename)
+	}
+
+	// Record the run in the ledger, including failed runs
+	sum, err := fileSHA256(filename)
 	if err != nil {
-		return fmt.Errorf("failed to read customers: %v", err)
+		return err
+	}
+	run := &models.ImportRun{
+		RunID:      report.RunID,
+		FileName:   filename,
+		FileSHA256: sum,
+		Operator:   imp.operator,
+		StartedAt:  report.Start,
 	}
-	log.Printf("Read %d customers from file", len(customers))
+	if err := ledger.BeginRun(run); err != nil {
+		return err
+	}
+
+	err = perform(report, filename)
 
-	accounts, err := readAccounts(f)
+	run.CustomerCount = report.CustomerCount
+	run.AccountCount = report.AccountCount
+	run.LinkCount = report.LinkCount
+	run.EntityCounts = report.EntityCounts
+	run.Status = models.RunSucceeded
 	if err != nil {
-		return fmt.Errorf("failed to read accounts: %v", err)
+		run.Status = models.RunFailed
+		run.ErrorSummary = err.Error()
+	}
+	if finishErr := ledger.FinishRun(run); finishErr != nil {
+		if err != nil {
+			log.Printf("Warning: %v", finishErr)
+			return err
+		}
+		return finishErr
 	}
-	log.Printf("Read %d accounts from file", len(accounts))
+	return err
+}
 
-	links, err := readLinks(f)
+// run performs the import, filling in the report as it goes
+func (imp *Importer) run(report *RunReport, filename string) error {
+	// Read all data first
+	readStart := time.Now()
+	wb, err := readWorkbook(filename, imp.only)
 	if err != nil {
-		return fmt.Errorf("failed to read customer-account links: %v", err)
+		return err
+	}
+	report.recordPhase("Read workbook", len(wb.Customers)+len(wb.Accounts)+len(wb.Links), readStart)
+
+	var errs models.ValidationErrors
+	if imp.transforms != nil {
+		if err := loadLookupSheets(filename, imp.transforms); err != nil {
+			return err
+		}
+		var rejects []transform.Reject
+		rejects, errs = imp.transforms.Apply(wb)
+		for _, rej := range rejects {
+			report.reject(rej.Sheet, rej.Row, rej.Reason)
+		}
+		if len(rejects) > 0 {
+			log.Printf("Rejected %d rows by transforms", len(rejects))
+		}
+	}
+	keepAttributes(wb, imp.attributes)
+
+	if imp.tenantMode {
+		errs = append(errs, AssignTenants(wb)...)
+		if imp.client != "" {
+			before := len(report.Rejects)
+			restrictToClient(wb, imp.client, report)
+			log.Printf("Rejected %d rows of other clients", len(report.Rejects)-before)
+		}
+	}
+
+	dedupe(wb, imp.duplicates, report)
+	if len(report.Duplicates) > 0 {
+		log.Printf("Found %d duplicated keys", len(report.Duplicates))
+	}
+
+	// Refuse to write anything if a row is invalid
+	errs = append(errs, wb.Validate()...)
+	if len(errs) > 0 {
+		LogValidationErrors(errs)
+		return errs
 	}
-	log.Printf("Read %d links from file", len(links))
 
-	// Insert customers
-	log.Printf("Inserting customers...")
-	customerIDs, err := imp.db.InsertCustomers(customers)
+	// Find links to unknown customers and accounts before anything is written
+	targetCustomerIDs, targetAccountIDs, err := imp.checkLinks(wb, report)
 	if err != nil {
-		return fmt.Errorf("failed to insert customers: %v", err)
+		return err
+	}
+
+	// Reduce the workbook to new and modified rows when a baseline is given
+	d := &delta{wb: wb}
+	var previous *manifest.Manifest
+	if imp.baseline != "" {
+		log.Printf("Reading baseline %s...", imp.baseline)
+		err := report.timePhase("Compare baseline", len(wb.Customers)+len(wb.Accounts)+len(wb.Links), func() error {
+			var err error
+			if strings.EqualFold(filepath.Ext(imp.baseline), ".json") {
+				previous, err = manifest.Load(imp.baseline)
+				if err != nil {
+					return fmt.Errorf("failed to read baseline: %v", err)
+				}
+				d = deltaFromManifest(previous, wb)
+				return nil
+			}
+
+			baseline, err := readWorkbook(imp.baseline, imp.only)
+			if err != nil {
+				return fmt.Errorf("failed to read baseline: %v", err)
+			}
+			if imp.transforms != nil {
+				imp.transforms.Apply(baseline)
+			}
+			keepAttributes(baseline, imp.attributes)
+			if imp.tenantMode {
+				// Rows of the baseline that cannot be scoped just never match
+				AssignTenants(baseline)
+			}
+			resolver, _ := imp.db.(models.IDResolver)
+			d, err = deltaFromWorkbook(baseline, wb, resolver)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	links := d.wb.Links
+
+	customerIDs, accountIDs, err := imp.loadEntities(report, d.wb)
+	if err != nil {
+		return err
+	}
+
+	// Insert customer-account links, resolving unchanged rows from the baseline
+	// and rows outside the workbook from the target
+	if linkRepo, ok := imp.db.(models.CustomerAccountRepository); ok {
+		log.Printf("Inserting customer-account links...")
+		err = report.timePhase("Links", len(links), func() error {
+			allCustomerIDs := mergeIDs(targetCustomerIDs, d.customerIDs, customerIDs)
+			allAccountIDs := mergeIDs(targetAccountIDs, d.accountIDs, accountIDs)
+			if err := imp.resolveLinkIDs(wb, links, allCustomerIDs, allAccountIDs); err != nil {
+				return err
+			}
+			written, err := linkRepo.InsertCustomerAccounts(links, allCustomerIDs, allAccountIDs)
+			report.LinkCount = written
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to insert customer-account links: %v", err)
+		}
+	}
+
+	if imp.manifest != "" {
+		sentLinks := make(map[string]bool, len(links))
+		for _, l := range links {
+			sentLinks[l.Key()] = true
+		}
+		m := buildManifest(report.RunID, filename, wb, previous, d, customerIDs, accountIDs, sentLinks)
+		if err := m.Save(imp.manifest); err != nil {
+			return err
+		}
+		log.Printf("Wrote manifest with %d entries to %s", len(m.Entries), imp.manifest)
+	}
+
+	report.Log()
+	log.Printf("Import completed successfully in %v", time.Since(report.Start))
+	return nil
+}
+
+// loadEntities inserts customers and accounts, concurrently when the
+// repository supports it since neither depends on the other
+func (imp *Importer) loadEntities(report *RunReport, wb *models.Workbook) (customerIDs, accountIDs map[string]int, err error) {
+	insertCustomers := func() error {
+		log.Printf("Inserting customers...")
+		err := report.timePhase("Customers", len(wb.Customers), func() error {
+			var err error
+			customerIDs, err = imp.db.InsertCustomers(wb.Customers)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to insert customers: %v", err)
+		}
+		report.CustomerCount = len(customerIDs)
+		log.Printf("Inserted/Updated %d customers", len(customerIDs))
+		return nil
 	}
-	log.Printf("Inserted/Updated %d customers", len(customerIDs))
 
-	// Insert accounts
 	// Note: We need to cast the interface to use AccountRepository methods
-	if accountRepo, ok := imp.db.(models.AccountRepository); ok {
+	accountIDs = make(map[string]int)
+	insertAccounts := func() error {
+		accountRepo, ok := imp.db.(models.AccountRepository)
+		if !ok {
+			return nil
+		}
 		log.Printf("Inserting accounts...")
-		accountIDs, err := accountRepo.InsertAccounts(accounts)
+		err := report.timePhase("Accounts", len(wb.Accounts), func() error {
+			var err error
+			accountIDs, err = accountRepo.InsertAccounts(wb.Accounts)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("failed to insert accounts: %v", err)
 		}
+		report.AccountCount = len(accountIDs)
 		log.Printf("Inserted/Updated %d accounts", len(accountIDs))
+		return nil
+	}
 
-		// Insert customer-account links
-		if linkRepo, ok := imp.db.(models.CustomerAccountRepository); ok {
-			log.Printf("Inserting customer-account links...")
-			err = linkRepo.InsertCustomerAccounts(links, customerIDs, accountIDs)
-			if err != nil {
-				return fmt.Errorf("failed to insert customer-account links: %v", err)
-			}
+	if loader, ok := imp.db.(models.ParallelLoader); !ok || !loader.SupportsParallelLoad() {
+		if err := insertCustomers(); err != nil {
+			return nil, nil, err
 		}
+		if err := insertAccounts(); err != nil {
+			return nil, nil, err
+		}
+		return customerIDs, accountIDs, nil
 	}
 
-	log.Printf("Import completed successfully in %v", time.Since(start))
-	return nil
+	var customerErr, accountErr error
+	err = report.timePhase("Customers + accounts", len(wb.Customers)+len(wb.Accounts), func() error {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			customerErr = insertCustomers()
+		}()
+		go func() {
+			defer wg.Done()
+			accountErr = insertAccounts()
+		}()
+		wg.Wait()
+		return errors.Join(customerErr, accountErr)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return customerIDs, accountIDs, nil
 }
-func readCustomers(f *excelize.File) ([]models.Customer, error) {
-	rows, err := f.GetRows("Customers")
+
+// loadLookupSheets fills the lookup tables the rules read from sheets of the
+// imported workbook
+func loadLookupSheets(filename string, rules *transform.Rules) error {
+	sheets := rules.Sheets()
+	if len(sheets) == 0 {
+		return nil
+	}
+
+	f, err := excelize.OpenFile(filename)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to open Excel file: %v", err)
 	}
+	defer f.Close()
 
-	var customers []models.Customer
-	for i, row := range rows {
-		if i == 0 { // Skip header
-			continue
+	for _, sheet := range sheets {
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			return fmt.Errorf("failed to read lookup sheet %q: %v", sheet, err)
 		}
-		if len(row) < 6 {
-			continue // Skip incomplete rows
+		if err := rules.FillSheet(sheet, rows); err != nil {
+			return err
 		}
-		customers = append(customers, models.Customer{
-			ClientID:       row[0],
-			CustomerNumber: row[1],
-			CustomerName:   row[2],
-			Address:        row[3],
-			Name:           row[4],
-			Email:          row[5],
-		})
 	}
-	return customers, nil
+	return nil
 }
 
-func readAccounts(f *excelize.File) ([]models.Account, error) {
-	rows, err := f.GetRows("Account")
+// LogValidationErrors logs the first validation errors of a workbook
+func LogValidationErrors(errs models.ValidationErrors) {
+	const maxLogged = 50
+	for i, e := range errs {
+		if i == maxLogged {
+			log.Printf("... and %d more", len(errs)-maxLogged)
+			break
+		}
+		log.Printf("Invalid: %v", e)
+	}
+}
+
+// fileSHA256 returns the hex SHA-256 of a file's contents
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("failed to open Excel file: %v", err)
 	}
+	defer f.Close()
 
-	var accounts []models.Account
-	for i, row := range rows {
-		if i == 0 { // Skip header
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %v", filename, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// mergeIDs combines ID maps, later maps taking precedence
+func mergeIDs(maps ...map[string]int) map[string]int {
+	merged := make(map[string]int)
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Column layouts of the sheets, in the order WriteWorkbook writes them
+var (
+	customerColumns = []string{"Client ID", "Customer Number", "Customer Name", "Address", "Name", "Email"}
+	accountColumns  = []string{"Account Number", "Account Name"}
+	linkColumns     = []string{"Customer Number", "Account Number"}
+)
+
+// clientIDColumn is optional on the account and link sheets, where it scopes
+// rows in tenant mode
+const clientIDColumn = "Client ID"
+
+// sheetColumns locates the columns of a sheet
+type sheetColumns struct {
+	index map[string]int // Layout and optional columns
+	extra map[string]int // Every other column with a header, by header
+}
+
+// columnsOf locates the columns of layout by the header row. Headers are
+// matched case-insensitively; a sheet with none of the expected headers is
+// read in the standard layout order.
+func columnsOf(sheet string, header []string, layout []string, optional ...string) (sheetColumns, error) {
+	byName := make(map[string]int, len(header))
+	for i, h := range header {
+		byName[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+
+	cols := sheetColumns{index: make(map[string]int), extra: make(map[string]int)}
+	var missing []string
+	for i, name := range layout {
+		if idx, ok := byName[strings.ToLower(name)]; ok {
+			cols.index[name] = idx
+		} else {
+			cols.index[name] = i
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 && len(missing) < len(layout) {
+		return sheetColumns{}, fmt.Errorf("sheet %q is missing columns: %s", sheet, strings.Join(missing, ", "))
+	}
+
+	for _, name := range optional {
+		if idx, ok := byName[strings.ToLower(name)]; ok {
+			cols.index[name] = idx
+		}
+	}
+
+	used := make(map[int]bool, len(cols.index))
+	for _, idx := range cols.index {
+		used[idx] = true
+	}
+	for i, h := range header {
+		if h = strings.TrimSpace(h); h != "" && !used[i] {
+			cols.extra[h] = i
+		}
+	}
+	return cols, nil
+}
+
+// get returns the named cell of a row, empty when the column or cell is
+// missing since trailing empty cells are not returned
+func (c sheetColumns) get(row []string, name string) string {
+	i, ok := c.index[name]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// attributes returns the non-empty cells of a row outside the known columns,
+// by header, or nil when there are none
+func (c sheetColumns) attributes(row []string) map[string]string {
+	var attrs map[string]string
+	for name, i := range c.extra {
+		if i >= len(row) || strings.TrimSpace(row[i]) == "" {
 			continue
 		}
-		if len(row) < 2 {
-			continue // Skip incomplete rows
+		if attrs == nil {
+			attrs = make(map[string]string)
 		}
-		accounts = append(accounts, models.Account{
-			AccountNumber: row[0],
-			AccountName:   row[1],
-		})
+		attrs[name] = row[i]
 	}
-	return accounts, nil
+	return attrs
 }
 
-func readLinks(f *excelize.File) ([]models.CustomerAccount, error) {
-	rows, err := f.GetRows("customer account link")
+// readSheet calls fn for every non-blank data row of a sheet with its
+// 1-based sheet row number. A missing sheet has no rows.
+func readSheet(f *excelize.File, sheet string, layout []string, optional []string,
+	fn func(cols sheetColumns, row []string, rowNum int)) error {
+	if !hasSheet(f, sheet) {
+		log.Printf("Sheet %q not found, reading it as empty", sheet)
+		return nil
+	}
+	rows, err := f.GetRows(sheet)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
 	}
 
-	var links []models.CustomerAccount
-	for i, row := range rows {
-		if i == 0 { // Skip header
+	cols, err := columnsOf(sheet, rows[0], layout, optional...)
+	if err != nil {
+		return err
+	}
+	for i, row := range rows[1:] {
+		if isBlank(row) {
 			continue
 		}
-		if len(row) < 2 {
-			continue // Skip incomplete rows
-		}
+		fn(cols, row, i+2)
+	}
+	return nil
+}
+
+func readCustomers(f *excelize.File) ([]models.Customer, error) {
+	var customers []models.Customer
+	err := readSheet(f, models.CustomersSheet, customerColumns, nil, func(cols sheetColumns, row []string, rowNum int) {
+		customers = append(customers, models.Customer{
+			ClientID:       cols.get(row, "Client ID"),
+			CustomerNumber: cols.get(row, "Customer Number"),
+			CustomerName:   cols.get(row, "Customer Name"),
+			Address:        cols.get(row, "Address"),
+			Name:           cols.get(row, "Name"),
+			Email:          cols.get(row, "Email"),
+			Attributes:     cols.attributes(row),
+			Row:            rowNum,
+		})
+	})
+	return customers, err
+}
+
+func readAccounts(f *excelize.File) ([]models.Account, error) {
+	var accounts []models.Account
+	err := readSheet(f, models.AccountsSheet, accountColumns, []string{clientIDColumn}, func(cols sheetColumns, row []string, rowNum int) {
+		accounts = append(accounts, models.Account{
+			ClientID:      cols.get(row, clientIDColumn),
+			AccountNumber: cols.get(row, "Account Number"),
+			AccountName:   cols.get(row, "Account Name"),
+			Attributes:    cols.attributes(row),
+			Row:           rowNum,
+		})
+	})
+	return accounts, err
+}
+
+func readLinks(f *excelize.File) ([]models.CustomerAccount, error) {
+	var links []models.CustomerAccount
+	err := readSheet(f, models.LinksSheet, linkColumns, []string{clientIDColumn}, func(cols sheetColumns, row []string, rowNum int) {
 		links = append(links, models.CustomerAccount{
-			CustomerNumber: row[0],
-			AccountNumber:  row[1],
+			ClientID:       cols.get(row, clientIDColumn),
+			CustomerNumber: cols.get(row, "Customer Number"),
+			AccountNumber:  cols.get(row, "Account Number"),
+			Attributes:     cols.attributes(row),
+			Row:            rowNum,
 		})
+	})
+	return links, err
+}
+
+// hasSheet reports whether a workbook contains the named sheet
+func hasSheet(f *excelize.File, sheet string) bool {
+	index, err := f.GetSheetIndex(sheet)
+	return err == nil && index >= 0
+}
+
+// isBlank reports whether every cell of a row is empty
+func isBlank(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
 	}
-	return links, nil
+	return true
 }
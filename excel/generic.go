@@ -0,0 +1,133 @@
+package excel
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"importer/entity"
+	"importer/models"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// SetDefinition imports the entities of a definition instead of the built-in
+// customers, accounts and links. The repository must implement
+// entity.Repository.
+func (imp *Importer) SetDefinition(def *entity.Definition) {
+	imp.definition = def
+}
+
+// ReadRecords reads the sheet of an entity, locating its fields by header
+func ReadRecords(f *excelize.File, e *entity.Entity) ([]entity.Record, error) {
+	var records []entity.Record
+	headers := e.Headers()
+	err := readSheet(f, e.Sheet, headers, nil, func(cols sheetColumns, row []string, rowNum int) {
+		values := make(map[string]string, len(e.Fields))
+		for i, f := range e.Fields {
+			values[f.Name] = cols.get(row, headers[i])
+		}
+		records = append(records, entity.Record{Values: values, Row: rowNum})
+	})
+	return records, err
+}
+
+// runDefinition performs an import driven by the entity definition: every
+// entity is written after the entities it references, whose IDs resolve the
+// references. Rows referencing a key that was not written are rejected.
+// Transforms, attributes and the link checks of the built-in import do not
+// apply.
+func (imp *Importer) runDefinition(report *RunReport, filename string) error {
+	repo, ok := imp.db.(entity.Repository)
+	if !ok {
+		return fmt.Errorf("the configured backend does not support entity definitions")
+	}
+
+	order, err := imp.definition.Order()
+	if err != nil {
+		return err
+	}
+
+	readStart := time.Now()
+	f, err := excelize.OpenFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open Excel file: %v", err)
+	}
+	records := make(map[string][]entity.Record, len(order))
+	total := 0
+	for _, e := range imp.definition.Entities {
+		rs, err := ReadRecords(f, e)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to read %s: %v", e.Name, err)
+		}
+		log.Printf("Read %d %s rows from file", len(rs), e.Name)
+		records[e.Name] = dedupeRecords(e, rs, imp.duplicates, report)
+		total += len(rs)
+	}
+	f.Close()
+	report.recordPhase("Read workbook", total, readStart)
+
+	// Refuse to write anything if a row is invalid
+	var errs models.ValidationErrors
+	for _, e := range imp.definition.Entities {
+		errs = append(errs, e.Validate(records[e.Name])...)
+	}
+	if len(errs) > 0 {
+		LogValidationErrors(errs)
+		return errs
+	}
+
+	if err := repo.PrepareEntities(imp.definition); err != nil {
+		return err
+	}
+
+	report.EntityCounts = make(map[string]int, len(order))
+	ids := make(map[string]map[string]int, len(order))
+	for _, e := range order {
+		rows := resolvable(report, e, records[e.Name], ids)
+		log.Printf("Writing %s rows...", e.Name)
+		err := report.timePhase(e.Name, len(rows), func() error {
+			var err error
+			ids[e.Name], err = repo.UpsertRecords(e, rows, ids)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write %s rows: %v", e.Name, err)
+		}
+		report.EntityCounts[e.Name] = len(rows)
+		log.Printf("Inserted/Updated %d %s rows", len(rows), e.Name)
+	}
+
+	report.Log()
+	log.Printf("Import completed successfully in %v", time.Since(report.Start))
+	return nil
+}
+
+// resolvable returns the records of e whose references were all written,
+// rejecting the rest. Empty reference fields are left to the target.
+func resolvable(report *RunReport, e *entity.Entity, records []entity.Record, ids map[string]map[string]int) []entity.Record {
+	if len(e.References) == 0 {
+		return records
+	}
+	var kept []entity.Record
+	for _, r := range records {
+		missing := ""
+		for _, ref := range e.References {
+			value := r.Values[ref.Field]
+			if value == "" {
+				continue
+			}
+			if _, ok := ids[ref.Entity][value]; !ok {
+				missing = fmt.Sprintf("%s %s not found", ref.Entity, value)
+				break
+			}
+		}
+		if missing != "" {
+			report.reject(e.Sheet, r.Row, missing)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
@@ -0,0 +1,152 @@
+package excel
+
+import (
+	"fmt"
+	"log"
+
+	"importer/models"
+)
+
+// Policies for links whose customer or account cannot be found
+const (
+	OrphansSkip  = "skip"  // Leave the link out and list it as rejected
+	OrphansAbort = "abort" // Fail the run before anything is written
+)
+
+// checkLinks resolves the customer and account of every link against the
+// workbook and, if enabled, the target, before anything is written. Orphan
+// links are removed and rejected, or returned as validation errors with the
+// abort policy. The IDs of the customers and accounts found in the target
+// are returned.
+func (imp *Importer) checkLinks(wb *models.Workbook, report *RunReport) (customerIDs, accountIDs map[string]int, err error) {
+	customers := make(map[string]bool, len(wb.Customers))
+	for _, c := range wb.Customers {
+		customers[c.Key()] = true
+	}
+	accounts := make(map[string]bool, len(wb.Accounts))
+	for _, a := range wb.Accounts {
+		accounts[a.Key()] = true
+	}
+
+	if imp.checkTarget {
+		customerIDs, accountIDs, err = imp.resolveMissing(wb.Links, customers, accounts)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var errs models.ValidationErrors
+	kept := wb.Links[:0]
+	for _, l := range wb.Links {
+		var problems []models.ValidationError
+		if _, ok := customerIDs[l.CustomerKey()]; !ok && !customers[l.CustomerKey()] {
+			problems = append(problems, models.ValidationError{Field: "customer_number",
+				Message: fmt.Sprintf("customer %s not found", l.CustomerKey())})
+		}
+		if _, ok := accountIDs[l.AccountKey()]; !ok && !accounts[l.AccountKey()] {
+			problems = append(problems, models.ValidationError{Field: "account_number",
+				Message: fmt.Sprintf("account %s not found", l.AccountKey())})
+		}
+		if len(problems) == 0 {
+			kept = append(kept, l)
+			continue
+		}
+		for _, p := range problems {
+			errs = append(errs, models.RowError{Sheet: models.LinksSheet, Row: l.Row, ValidationError: p})
+			if imp.orphans != OrphansAbort {
+				report.reject(models.LinksSheet, l.Row, p.Message)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return customerIDs, accountIDs, nil
+	}
+
+	if imp.orphans == OrphansAbort {
+		LogValidationErrors(errs)
+		return nil, nil, errs
+	}
+	log.Printf("Skipping %d links whose customer or account was not found", len(wb.Links)-len(kept))
+	wb.Links = kept
+	return customerIDs, accountIDs, nil
+}
+
+// resolveMissing looks up the customers and accounts that links refer to
+// but the workbook does not contain
+func (imp *Importer) resolveMissing(links []models.CustomerAccount, customers, accounts map[string]bool) (customerIDs, accountIDs map[string]int, err error) {
+	resolver, ok := imp.db.(models.IDResolver)
+	if !ok {
+		return nil, nil, fmt.Errorf("the configured backend cannot look up existing customers and accounts")
+	}
+
+	var customerKeys, accountKeys []models.NaturalKey
+	seen := make(map[string]bool)
+	for _, l := range links {
+		if k := l.CustomerKey(); !customers[k] && !seen["c:"+k] {
+			seen["c:"+k] = true
+			customerKeys = append(customerKeys, models.NaturalKey{Tenant: l.Tenant, Number: l.CustomerNumber})
+		}
+		if k := l.AccountKey(); !accounts[k] && !seen["a:"+k] {
+			seen["a:"+k] = true
+			accountKeys = append(accountKeys, models.NaturalKey{Tenant: l.Tenant, Number: l.AccountNumber})
+		}
+	}
+
+	customerIDs, accountIDs = make(map[string]int), make(map[string]int)
+	if len(customerKeys) == 0 && len(accountKeys) == 0 {
+		return customerIDs, accountIDs, nil
+	}
+	if len(customerKeys) > 0 {
+		if customerIDs, err = resolver.ResolveCustomerIDs(customerKeys); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(accountKeys) > 0 {
+		if accountIDs, err = resolver.ResolveAccountIDs(accountKeys); err != nil {
+			return nil, nil, err
+		}
+	}
+	log.Printf("Found %d of %d customers and %d of %d accounts of links in the target",
+		len(customerIDs), len(customerKeys), len(accountIDs), len(accountKeys))
+	return customerIDs, accountIDs, nil
+}
+
+// resolveLinkIDs adds the IDs of the customers and accounts that links refer
+// to but neither this run nor the baseline provided, looked up in the target.
+// Only rows of the workbook are looked up: checkLinks already queried the
+// others. Nothing is looked up without SetCheckTarget.
+func (imp *Importer) resolveLinkIDs(wb *models.Workbook, links []models.CustomerAccount, customerIDs, accountIDs map[string]int) error {
+	if !imp.checkTarget {
+		return nil
+	}
+
+	// Skip keys with an ID and keys outside the workbook
+	customers := make(map[string]bool, len(links))
+	accounts := make(map[string]bool, len(links))
+	for _, l := range links {
+		customers[l.CustomerKey()] = true
+		accounts[l.AccountKey()] = true
+	}
+	for _, c := range wb.Customers {
+		if _, ok := customerIDs[c.Key()]; !ok {
+			delete(customers, c.Key())
+		}
+	}
+	for _, a := range wb.Accounts {
+		if _, ok := accountIDs[a.Key()]; !ok {
+			delete(accounts, a.Key())
+		}
+	}
+
+	foundCustomers, foundAccounts, err := imp.resolveMissing(links, customers, accounts)
+	if err != nil {
+		return err
+	}
+	for k, id := range foundCustomers {
+		customerIDs[k] = id
+	}
+	for k, id := range foundAccounts {
+		accountIDs[k] = id
+	}
+	return nil
+}
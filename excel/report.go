@@ -0,0 +1,128 @@
+package excel
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Phase is the timing of one step of an import run
+type Phase struct {
+	Name     string
+	Rows     int
+	Duration time.Duration
+}
+
+// Reject is a row left out of an import run
+type Reject struct {
+	Sheet  string
+	Row    int
+	Reason string
+}
+
+// maxLoggedRejects bounds the rejects listed in the run summary
+const maxLoggedRejects = 50
+
+// RunReport collects what happened during an import run
+type RunReport struct {
+	RunID  string
+	File   string
+	Start  time.Time
+	Phases []Phase
+
+	// Rows sent to the repository
+	CustomerCount int
+	AccountCount  int
+	LinkCount     int
+	EntityCounts  map[string]int // Runs of entity definitions only
+
+	Rejects    []Reject
+	Duplicates []Duplicate
+
+	mu sync.Mutex
+}
+
+func newRunReport(runID, file string) *RunReport {
+	return &RunReport{
+		RunID: runID,
+		File:  file,
+		Start: time.Now(),
+	}
+}
+
+// timePhase runs fn and records how long it took. It is safe to call from
+// concurrently running phases.
+func (r *RunReport) timePhase(name string, rows int, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	r.recordPhase(name, rows, start)
+	return err
+}
+
+// recordPhase records a phase that started at start and has just finished
+func (r *RunReport) recordPhase(name string, rows int, start time.Time) {
+	r.mu.Lock()
+	r.Phases = append(r.Phases, Phase{Name: name, Rows: rows, Duration: time.Since(start)})
+	r.mu.Unlock()
+}
+
+// reject records a row left out of the run
+func (r *RunReport) reject(sheet string, row int, reason string) {
+	r.mu.Lock()
+	r.Rejects = append(r.Rejects, Reject{Sheet: sheet, Row: row, Reason: reason})
+	r.mu.Unlock()
+}
+
+// duplicate records a key repeated within a sheet
+func (r *RunReport) duplicate(d Duplicate) {
+	r.mu.Lock()
+	r.Duplicates = append(r.Duplicates, d)
+	r.mu.Unlock()
+}
+
+// Log prints the run summary
+func (r *RunReport) Log() {
+	log.Printf("Import Summary (run %s):", r.RunID)
+	log.Printf("------------------")
+	for _, p := range r.Phases {
+		log.Printf("%-22s %8d rows  %v", p.Name+":", p.Rows, p.Duration.Round(time.Millisecond))
+	}
+	log.Printf("%-22s %13s  %v", "Total:", "", time.Since(r.Start).Round(time.Millisecond))
+
+	if len(r.Rejects) > 0 {
+		log.Printf("Rejected %d rows:", len(r.Rejects))
+		for i, rej := range r.Rejects {
+			if i == maxLoggedRejects {
+				log.Printf("  ... and %d more", len(r.Rejects)-maxLoggedRejects)
+				break
+			}
+			log.Printf("  %s row %d: %s", rej.Sheet, rej.Row, rej.Reason)
+		}
+	}
+
+	if len(r.Duplicates) > 0 {
+		log.Printf("Duplicate keys (%d):", len(r.Duplicates))
+		for i, d := range r.Duplicates {
+			if i == maxLoggedRejects {
+				log.Printf("  ... and %d more", len(r.Duplicates)-maxLoggedRejects)
+				break
+			}
+			outcome := "all rejected"
+			if d.Kept != 0 {
+				outcome = fmt.Sprintf("kept row %d", d.Kept)
+			}
+			log.Printf("  %s %s on rows %s: %s", d.Sheet, d.Key, joinRows(d.Rows), outcome)
+		}
+	}
+}
+
+func joinRows(rows []int) string {
+	parts := make([]string, len(rows))
+	for i, row := range rows {
+		parts[i] = strconv.Itoa(row)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,128 @@
+package excel
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"importer/models"
+)
+
+// AssignTenants scopes every row of the workbook by client ID for tenant
+// mode. Customers belong to their own client. Links and accounts without a
+// Client ID column inherit the tenant of their customer and of their links
+// respectively, which must be unambiguous.
+func AssignTenants(wb *models.Workbook) models.ValidationErrors {
+	var errs models.ValidationErrors
+	tenantErr := func(sheet string, row int, msg string) {
+		errs = append(errs, models.RowError{
+			Sheet:           sheet,
+			Row:             row,
+			ValidationError: models.ValidationError{Field: "client_id", Message: msg},
+		})
+	}
+
+	customerTenants := make(map[string]map[string]bool)
+	for i := range wb.Customers {
+		c := &wb.Customers[i]
+		c.Tenant = c.ClientID
+		addTenant(customerTenants, c.CustomerNumber, c.Tenant)
+	}
+
+	accountTenants := make(map[string]map[string]bool)
+	for i := range wb.Links {
+		l := &wb.Links[i]
+		if l.ClientID != "" {
+			l.Tenant = l.ClientID
+		} else {
+			tenant, err := onlyTenant(customerTenants[l.CustomerNumber], "customer "+l.CustomerNumber)
+			if err != nil {
+				tenantErr(models.LinksSheet, l.Row, err.Error())
+				continue
+			}
+			l.Tenant = tenant
+		}
+		addTenant(accountTenants, l.AccountNumber, l.Tenant)
+	}
+
+	for i := range wb.Accounts {
+		a := &wb.Accounts[i]
+		if a.ClientID != "" {
+			a.Tenant = a.ClientID
+			continue
+		}
+		tenant, err := onlyTenant(accountTenants[a.AccountNumber], "account "+a.AccountNumber)
+		if err != nil {
+			tenantErr(models.AccountsSheet, a.Row, err.Error())
+			continue
+		}
+		a.Tenant = tenant
+	}
+	return errs
+}
+
+func addTenant(tenants map[string]map[string]bool, number, tenant string) {
+	if tenants[number] == nil {
+		tenants[number] = make(map[string]bool)
+	}
+	tenants[number][tenant] = true
+}
+
+// onlyTenant returns the single tenant of a row inherited from what it
+// refers to
+func onlyTenant(tenants map[string]bool, what string) (string, error) {
+	switch len(tenants) {
+	case 0:
+		return "", fmt.Errorf("is required in tenant mode, %s has no client in this file", what)
+	case 1:
+		for t := range tenants {
+			return t, nil
+		}
+	}
+
+	names := make([]string, 0, len(tenants))
+	for t := range tenants {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("is required in tenant mode, %s belongs to several clients (%s)", what, strings.Join(names, ", "))
+}
+
+// restrictToClient keeps only the rows of one tenant, recording the others as
+// rejects. Rows whose tenant could not be determined are kept so they are
+// reported as validation errors.
+func restrictToClient(wb *models.Workbook, client string, report *RunReport) {
+	reason := func(tenant string) string {
+		return fmt.Sprintf("belongs to client %q, import is restricted to %q", tenant, client)
+	}
+
+	customers := wb.Customers[:0]
+	for _, c := range wb.Customers {
+		if c.Tenant != client {
+			report.reject(models.CustomersSheet, c.Row, reason(c.Tenant))
+			continue
+		}
+		customers = append(customers, c)
+	}
+	wb.Customers = customers
+
+	accounts := wb.Accounts[:0]
+	for _, a := range wb.Accounts {
+		if a.Tenant != "" && a.Tenant != client {
+			report.reject(models.AccountsSheet, a.Row, reason(a.Tenant))
+			continue
+		}
+		accounts = append(accounts, a)
+	}
+	wb.Accounts = accounts
+
+	links := wb.Links[:0]
+	for _, l := range wb.Links {
+		if l.Tenant != "" && l.Tenant != client {
+			report.reject(models.LinksSheet, l.Row, reason(l.Tenant))
+			continue
+		}
+		links = append(links, l)
+	}
+	wb.Links = links
+}
@@ -1,66 +1,180 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
-	"time"
+	"os"
+	"sort"
 
 	_ "github.com/lib/pq"
 
 	"importer/api"
 	"importer/config"
 	"importer/db"
-	"importer/excel"
-	"importer/generator"
 	"importer/models"
 )
 
+// Exit codes
+const (
+	exitOK         = 0
+	exitFailure    = 1  // System failure: I/O, database or API errors
+	exitValidation = 2  // The input file failed validation
+	exitUsage      = 64 // Unknown command or bad flags
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"generate", "Generate a workbook of test data", runGenerate},
+	{"import", "Import a workbook into the configured backend", runImport},
+	{"verify", "Validate a workbook without importing it", runVerify},
+	{"export", "Export the backend contents to a workbook", runExport},
+	{"diff", "Compare two workbooks", runDiff},
+	{"match", "Report customers that may duplicate existing ones under other numbers", runMatch},
+	{"migrate", "Apply, revert or list database schema migrations", runMigrate},
+	{"mockapi", "Run the mock API server", runMockAPI},
+	{"runs", "List recent import runs from the ledger", runRuns},
+	{"rollback", "Revert the changes of one import run", runRollback},
+	{"history", "Show the field change history of a customer or account", runHistory},
+	{"definitions", "Print the default entity definitions, or check a definition file", runDefinitions},
+	{"secrets", "Encrypt a JSON file of secrets for SECRETS_FILE", runSecrets},
+}
+
+// usageError marks errors caused by bad command line arguments
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
 func main() {
-	// Parse command line flags
-	generateData := flag.Bool("generate", false, "Generate test data")
-	numRows := flag.Int("rows", 100000, "Number of rows to generate")
-	inputFile := flag.String("file", "test_data.xlsx", "Excel file to process")
-	flag.Parse()
-
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		log.Fatal(err)
+	// Scrub loaded secrets from everything logged, including wrapped driver errors
+	log.SetOutput(config.RedactingWriter(os.Stderr))
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage()
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
 	}
 
-	if *generateData {
-		gen := generator.NewGenerator(generator.GeneratorConfig{
-			NumCustomers:    *numRows,
-			MultiAcctChance: 0.3,
-			ThirdAcctChance: 0.1,
-			CustomerPrefix:  "CUST",
-			AccountPrefix:   "ACC",
-		})
-
-		start := time.Now()
-		if err := excel.GenerateFile(*inputFile, gen); err != nil {
-			log.Fatal(err)
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return exitCode(cmd.run(args[1:]))
 		}
-		log.Printf("Total generation time: %v", time.Since(start))
-		return
 	}
 
-	// Initialize either API client or DB based on config
-	var dataStore models.CustomerRepository
-	if cfg.API.UseAPI {
-		dataStore = api.NewClient(cfg)
-	} else {
-		postgresDB, err := db.NewPostgresDB(cfg)
-		if err != nil {
-			log.Fatal(err)
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage()
+	return exitUsage
+}
+
+func exitCode(err error) int {
+	var validationErrs models.ValidationErrors
+	var usageErr usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usageErr):
+		fmt.Fprintln(os.Stderr, config.Redact(err.Error()))
+		return exitUsage
+	case errors.As(err, &validationErrs):
+		log.Printf("Validation failed: %v", err)
+		return exitValidation
+	default:
+		log.Printf("Error: %v", err)
+		return exitFailure
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: importer <command> [flags]\n\nCommands:\n")
+	names := make([]command, len(commands))
+	copy(names, commands)
+	sort.Slice(names, func(i, j int) bool { return names[i].name < names[j].name })
+	for _, cmd := range names {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'importer <command> -h' for the flags of a command.\n")
+}
+
+// newFlagSet returns a flag set whose parse errors are reported as usage errors
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: importer %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
 		}
-		dataStore = postgresDB
+		return usageError{msg: err.Error()}
+	}
+	return nil
+}
+
+// configFlags are the flags shared by commands that open a repository
+type configFlags struct {
+	file    *string
+	profile *string
+	backend *string
+}
+
+func addConfigFlags(fs *flag.FlagSet) configFlags {
+	return configFlags{
+		file:    fs.String("config", "", "YAML config file (default $IMPORTER_CONFIG)"),
+		profile: fs.String("profile", "", "Profile of the config file to apply (default $IMPORTER_PROFILE)"),
+		backend: fs.String("backend", "", "Override the configured backend: postgres or api"),
+	}
+}
+
+// loadConfig loads and validates the configuration, applying the --backend override
+func loadConfig(flags configFlags) (*config.AppConfig, error) {
+	cfg, err := config.LoadConfig(config.Options{
+		File:    *flags.file,
+		Profile: *flags.profile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch *flags.backend {
+	case "":
+	case "postgres":
+		cfg.API.UseAPI = false
+	case "api":
+		cfg.API.UseAPI = true
+	default:
+		return nil, usageError{msg: fmt.Sprintf("unknown backend %q, expected postgres or api", *flags.backend)}
 	}
-	defer dataStore.Close()
 
-	// Process import
-	importer := excel.NewImporter(dataStore, cfg)
-	if err := importer.Import(*inputFile); err != nil {
-		log.Fatal(err)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// openRepository initializes either the API client or the database based on config
+func openRepository(cfg *config.AppConfig) (models.CustomerRepository, error) {
+	if cfg.API.UseAPI {
+		return api.NewClient(cfg), nil
 	}
+	return db.NewPostgresDB(cfg)
 }
@@ -0,0 +1,165 @@
+package manifest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"importer/models"
+)
+
+const (
+	EntityCustomer = "customer"
+	EntityAccount  = "account"
+	EntityLink     = "link"
+)
+
+// Row classifications against a previous manifest
+const (
+	StatusNew       = "new"
+	StatusChanged   = "changed"
+	StatusUnchanged = "unchanged"
+)
+
+// Entry records one imported row
+type Entry struct {
+	Entity   string `json:"entity"`
+	Key      string `json:"key"`
+	Hash     string `json:"hash"`
+	TargetID int    `json:"target_id,omitempty"`
+	RunID    string `json:"run_id,omitempty"`
+}
+
+// Manifest is the set of rows known to have been imported successfully
+type Manifest struct {
+	RunID     string    `json:"run_id"`
+	File      string    `json:"file"`
+	CreatedAt time.Time `json:"created_at"`
+	Entries   []Entry   `json:"entries"`
+
+	index map[string]int
+}
+
+// New creates an empty manifest for a run
+func New(runID, file string) *Manifest {
+	return &Manifest{
+		RunID:     runID,
+		File:      file,
+		CreatedAt: time.Now().UTC(),
+		index:     make(map[string]int),
+	}
+}
+
+// Load reads a manifest written by a previous run
+func Load(filename string) (*Manifest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %v", filename, err)
+	}
+
+	m.index = make(map[string]int, len(m.Entries))
+	for i, e := range m.Entries {
+		m.index[indexKey(e.Entity, e.Key)] = i
+	}
+	return &m, nil
+}
+
+// Save writes the manifest as JSON
+func (m *Manifest) Save(filename string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
+
+// Add records an entry, replacing any previous entry for the same row
+func (m *Manifest) Add(e Entry) {
+	k := indexKey(e.Entity, e.Key)
+	if i, ok := m.index[k]; ok {
+		m.Entries[i] = e
+		return
+	}
+	m.index[k] = len(m.Entries)
+	m.Entries = append(m.Entries, e)
+}
+
+// Lookup returns the entry for a row
+func (m *Manifest) Lookup(entity, key string) (Entry, bool) {
+	i, ok := m.index[indexKey(entity, key)]
+	if !ok {
+		return Entry{}, false
+	}
+	return m.Entries[i], true
+}
+
+// Classify reports whether a row is new, changed or unchanged since this manifest
+func (m *Manifest) Classify(entity, key, hash string) string {
+	e, ok := m.Lookup(entity, key)
+	switch {
+	case !ok:
+		return StatusNew
+	case e.Hash != hash:
+		return StatusChanged
+	default:
+		return StatusUnchanged
+	}
+}
+
+// HashFields returns the SHA-256 of the normalized field values. Values are
+// trimmed and runs of whitespace collapsed so formatting noise in the sheet
+// does not count as a change.
+func HashFields(fields []models.Field) string {
+	h := sha256.New()
+	for _, f := range fields {
+		h.Write([]byte(f.Name))
+		h.Write([]byte{0x1e})
+		h.Write([]byte(strings.Join(strings.Fields(f.Value), " ")))
+		h.Write([]byte{0x1f})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashCustomer returns the manifest hash of a customer. Attributes only
+// take part when present so hashes of rows without them are unchanged.
+func HashCustomer(c models.Customer) string {
+	return HashFields(append(c.Fields(), models.AttributeFields(c.Attributes)...))
+}
+
+// HashAccount returns the manifest hash of an account
+func HashAccount(a models.Account) string {
+	return HashFields(append(a.Fields(), models.AttributeFields(a.Attributes)...))
+}
+
+// HashLink returns the manifest hash of a customer-account link
+func HashLink(l models.CustomerAccount) string {
+	fields := []models.Field{
+		{Name: "customer_number", Value: l.CustomerNumber},
+		{Name: "account_number", Value: l.AccountNumber},
+	}
+	return HashFields(append(fields, models.AttributeFields(l.Attributes)...))
+}
+
+// NewRunID returns a sortable, unique identifier for an import run
+func NewRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+func indexKey(entity, key string) string {
+	return entity + "\x00" + key
+}
@@ -0,0 +1,212 @@
+// Package match finds customers that are probably the same real customer
+// under different customer numbers, for data stewards to review. Nothing is
+// merged automatically.
+package match
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"importer/models"
+	"importer/normalize"
+)
+
+// Weights of the field scores in a candidate's score. Fields missing on
+// either customer are left out and the remaining weights scaled up.
+const (
+	nameWeight    = 0.5
+	emailWeight   = 0.3
+	addressWeight = 0.2
+)
+
+// maxBlock bounds the customers compared for one blocking key, so a very
+// common name word does not turn matching quadratic
+const maxBlock = 1000
+
+// Candidate is a pair of customers that may be duplicates. Field scores are
+// -1 when the field is missing on either customer.
+type Candidate struct {
+	Score        float64         `json:"score"`
+	NameScore    float64         `json:"name_score"`
+	EmailScore   float64         `json:"email_score"`
+	AddressScore float64         `json:"address_score"`
+	Incoming     models.Customer `json:"incoming"`
+	Existing     models.Customer `json:"existing"`
+}
+
+// Report lists the candidate duplicates, best first
+type Report struct {
+	IncomingFile string      `json:"incoming_file"`
+	Existing     string      `json:"existing"`
+	Threshold    float64     `json:"threshold"`
+	Compared     int         `json:"compared"` // Pairs scored
+	Candidates   []Candidate `json:"candidates"`
+}
+
+// profile is a customer normalized for comparison
+type profile struct {
+	customer models.Customer
+	name     string
+	email    string
+	local    string
+	domain   string
+	address  string
+	postcode string
+}
+
+// Find compares every incoming customer with the existing customers sharing
+// a name word, email address or postcode with it, and reports the pairs
+// scoring at least threshold (0 to 1). Pairs with the same customer number
+// are the same record and are skipped, whether or not both sides carry a
+// tenant.
+func Find(incoming, existing []models.Customer, threshold float64) *Report {
+	report := &Report{Threshold: threshold}
+
+	blocks := make(map[string][]int)
+	profiles := make([]profile, len(existing))
+	for i, c := range existing {
+		profiles[i] = newProfile(c)
+		for _, key := range blockingKeys(profiles[i]) {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+
+	for _, c := range incoming {
+		p := newProfile(c)
+		seen := make(map[int]bool)
+		for _, key := range blockingKeys(p) {
+			block := blocks[key]
+			if len(block) > maxBlock {
+				continue
+			}
+			for _, i := range block {
+				if seen[i] || sameCustomer(profiles[i].customer, c) {
+					continue
+				}
+				seen[i] = true
+				report.Compared++
+				if cand := score(p, profiles[i]); cand.Score >= threshold {
+					report.Candidates = append(report.Candidates, cand)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(report.Candidates, func(i, j int) bool {
+		return report.Candidates[i].Score > report.Candidates[j].Score
+	})
+	return report
+}
+
+// sameCustomer reports whether a and b have the same number, and the same
+// tenant when both have one. Rows exported from a tenant-mode target carry a
+// tenant that incoming rows may lack.
+func sameCustomer(a, b models.Customer) bool {
+	if a.Tenant == "" || b.Tenant == "" {
+		return a.CustomerNumber == b.CustomerNumber
+	}
+	return a.Key() == b.Key()
+}
+
+// Legal form suffixes ignored when comparing names
+var legalForms = map[string]bool{
+	"inc": true, "incorporated": true, "ltd": true, "limited": true, "llc": true, "llp": true,
+	"corp": true, "corporation": true, "co": true, "company": true, "plc": true, "gmbh": true,
+	"ag": true, "bv": true, "nv": true, "sa": true, "sarl": true, "srl": true, "pty": true,
+}
+
+func newProfile(c models.Customer) profile {
+	p := profile{customer: c}
+
+	var words []string
+	for w := range tokenSet(c.CustomerName) {
+		if !legalForms[w] {
+			words = append(words, w)
+		}
+	}
+	sort.Strings(words)
+	p.name = strings.Join(words, " ")
+
+	if email, err := normalize.NormalizeEmail(c.Email); err == nil {
+		p.email = strings.ToLower(email.Address)
+		p.local = strings.ToLower(email.Local)
+		p.domain = email.Domain
+	}
+
+	if c.Address != "" {
+		addr := normalize.ParseAddress(c.Address)
+		p.address = strings.ToLower(strings.Join(strings.Fields(c.Address), " "))
+		if addr.Postcode != "" {
+			p.address = strings.ToLower(addr.Street + " " + addr.City + " " + addr.Postcode)
+			p.postcode = strings.ReplaceAll(addr.Postcode, " ", "")
+		}
+	}
+	return p
+}
+
+// blockingKeys are the values two customers must share one of to be compared
+func blockingKeys(p profile) []string {
+	var keys []string
+	// Numbers in names, e.g. branch numbers, would pair unrelated customers
+	for _, w := range strings.Fields(p.name) {
+		if len(w) >= 3 && strings.IndexFunc(w, unicode.IsLetter) >= 0 {
+			keys = append(keys, "name:"+prefix(w, 4))
+		}
+	}
+	if p.email != "" {
+		keys = append(keys, "email:"+p.email)
+	}
+	if p.postcode != "" {
+		keys = append(keys, "postcode:"+p.postcode)
+	}
+	return keys
+}
+
+func prefix(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		r = r[:n]
+	}
+	return string(r)
+}
+
+func score(in, ex profile) Candidate {
+	cand := Candidate{
+		NameScore:    -1,
+		EmailScore:   -1,
+		AddressScore: -1,
+		Incoming:     in.customer,
+		Existing:     ex.customer,
+	}
+
+	var total, weights float64
+	if in.name != "" && ex.name != "" {
+		cand.NameScore = max(JaroWinkler(in.name, ex.name), TokenSet(in.name, ex.name))
+		total += nameWeight * cand.NameScore
+		weights += nameWeight
+	}
+	if in.email != "" && ex.email != "" {
+		// The same mailbox scores 1; otherwise similar local parts count, less
+		// so at different domains
+		cand.EmailScore = 1
+		if in.email != ex.email {
+			cand.EmailScore = JaroWinkler(in.local, ex.local)
+			if in.domain != ex.domain {
+				cand.EmailScore *= 0.8
+			}
+		}
+		total += emailWeight * cand.EmailScore
+		weights += emailWeight
+	}
+	if in.address != "" && ex.address != "" {
+		cand.AddressScore = TokenSet(in.address, ex.address)
+		total += addressWeight * cand.AddressScore
+		weights += addressWeight
+	}
+
+	if weights > 0 {
+		cand.Score = total / weights
+	}
+	return cand
+}
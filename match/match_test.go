@@ -0,0 +1,47 @@
+package match
+
+import (
+	"testing"
+
+	"importer/models"
+)
+
+func TestSameCustomer(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b models.Customer
+		want bool
+	}{
+		{"same number", models.Customer{CustomerNumber: "C1"}, models.Customer{CustomerNumber: "C1"}, true},
+		{"different number", models.Customer{CustomerNumber: "C1"}, models.Customer{CustomerNumber: "C2"}, false},
+		{"tenant on one side", models.Customer{CustomerNumber: "C1", Tenant: "t1"}, models.Customer{CustomerNumber: "C1"}, true},
+		{"tenant on the other side", models.Customer{CustomerNumber: "C1"}, models.Customer{CustomerNumber: "C1", Tenant: "t1"}, true},
+		{"same tenant", models.Customer{CustomerNumber: "C1", Tenant: "t1"}, models.Customer{CustomerNumber: "C1", Tenant: "t1"}, true},
+		{"different tenants", models.Customer{CustomerNumber: "C1", Tenant: "t1"}, models.Customer{CustomerNumber: "C1", Tenant: "t2"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameCustomer(tt.a, tt.b); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSkipsSelfPairs(t *testing.T) {
+	jane := models.Customer{CustomerNumber: "C1", CustomerName: "Jane Smith Ltd", Email: "jane@example.com"}
+	exported := jane
+	exported.Tenant = "t1"
+	other := models.Customer{CustomerNumber: "C2", CustomerName: "Jane Smith Limited", Email: "jane@example.com"}
+
+	report := Find([]models.Customer{jane}, []models.Customer{exported, other}, 0.9)
+	if report.Compared != 1 {
+		t.Errorf("compared %d pairs, want 1", report.Compared)
+	}
+	if len(report.Candidates) != 1 || report.Candidates[0].Existing.CustomerNumber != "C2" {
+		t.Fatalf("got candidates %+v, want C2 only", report.Candidates)
+	}
+	if c := report.Candidates[0]; c.Score != 1 || c.AddressScore != -1 {
+		t.Errorf("got score %.4f and address score %.4f, want 1 and -1", c.Score, c.AddressScore)
+	}
+}
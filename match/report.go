@@ -0,0 +1,70 @@
+package match
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal match report: %v", err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write match report: %v", err)
+	}
+	return nil
+}
+
+// WriteWorkbook writes a summary sheet and a sheet listing every candidate
+// pair side by side, for review
+func (r *Report) WriteWorkbook(filename string) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	summarySheet := "Summary"
+	f.SetSheetName("Sheet1", summarySheet)
+	f.SetSheetRow(summarySheet, "A1", &[]interface{}{"Incoming File", r.IncomingFile})
+	f.SetSheetRow(summarySheet, "A2", &[]interface{}{"Existing", r.Existing})
+	f.SetSheetRow(summarySheet, "A3", &[]interface{}{"Threshold", r.Threshold})
+	f.SetSheetRow(summarySheet, "A4", &[]interface{}{"Pairs Compared", r.Compared})
+	f.SetSheetRow(summarySheet, "A5", &[]interface{}{"Candidates", len(r.Candidates)})
+
+	sheet := "Candidates"
+	if _, err := f.NewSheet(sheet); err != nil {
+		return fmt.Errorf("failed to create sheet %s: %v", sheet, err)
+	}
+	f.SetSheetRow(sheet, "A1", &[]interface{}{
+		"Score", "Name Score", "Email Score", "Address Score",
+		"Incoming Row", "Incoming Number", "Incoming Name", "Incoming Email", "Incoming Address",
+		"Existing Number", "Existing Name", "Existing Email", "Existing Address",
+	})
+	for i, c := range r.Candidates {
+		f.SetSheetRow(sheet, fmt.Sprintf("A%d", i+2), &[]interface{}{
+			round(c.Score), fieldScore(c.NameScore), fieldScore(c.EmailScore), fieldScore(c.AddressScore),
+			c.Incoming.Row, c.Incoming.CustomerNumber, c.Incoming.CustomerName, c.Incoming.Email, c.Incoming.Address,
+			c.Existing.CustomerNumber, c.Existing.CustomerName, c.Existing.Email, c.Existing.Address,
+		})
+	}
+
+	if err := f.SaveAs(filename); err != nil {
+		return fmt.Errorf("failed to save match report: %v", err)
+	}
+	return nil
+}
+
+// fieldScore leaves fields that were not compared blank
+func fieldScore(s float64) interface{} {
+	if s < 0 {
+		return ""
+	}
+	return round(s)
+}
+
+func round(s float64) float64 {
+	return float64(int(s*1000+0.5)) / 1000
+}
@@ -0,0 +1,60 @@
+package match
+
+import (
+	"math"
+	"testing"
+)
+
+const tolerance = 0.0005
+
+func TestJaroWinkler(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"MARTHA", "MARHTA", 0.9611},
+		{"DIXON", "DICKSONX", 0.8133},
+		{"DWAYNE", "DUANE", 0.84},
+		{"JONES", "JOHNSON", 0.8324},
+		{"abcdxyz", "abcdpqr", 0.8286}, // Prefix bonus capped at four characters
+		{"ab", "ax", 0.6667},           // Jaro of 0.667 is too low for the prefix bonus
+		{"Zoë", "Zoe", 0.8222},         // Compared by rune, not byte
+		{"ABC", "XYZ", 0},
+		{"same", "same", 1},
+		{"", "", 1},
+		{"abc", "", 0},
+		{"", "abc", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := JaroWinkler(tt.a, tt.b); math.Abs(got-tt.want) > tolerance {
+				t.Errorf("got %.4f, want %.4f", got, tt.want)
+			}
+			if got, rev := JaroWinkler(tt.a, tt.b), JaroWinkler(tt.b, tt.a); math.Abs(got-rev) > tolerance {
+				t.Errorf("not symmetric: %.4f and %.4f", got, rev)
+			}
+		})
+	}
+}
+
+func TestTokenSet(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"Acme Trading Ltd", "Trading Acme", 1},
+		{"Acme Trading", "acme-trading", 1},
+		{"new york mets", "new york meats", 0.9286},
+		{"acme trading", "globex", 0},
+		{"", "", 1},
+		{"acme", "", 0},
+		{"", "--", 1}, // No words on either side
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := TokenSet(tt.a, tt.b); math.Abs(got-tt.want) > tolerance {
+				t.Errorf("got %.4f, want %.4f", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// mockapi/server.go
+package mockapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"importer/models"
+)
+
+type MockAPI struct {
+	customers        map[string]int // ClientID and CustomerNumber, as models.ScopedKey, to ID
+	accounts         map[string]int // ClientID and AccountNumber, as models.ScopedKey, to ID
+	clients          map[int]string // Customer or account ID to ClientID
+	numbers          map[int]string // Customer or account ID to number
+	customerAccounts []models.CustomerAccountLinkRequest
+	nextID           int
+	mu               sync.Mutex
+}
+
+func NewMockAPI() *MockAPI {
+	return &MockAPI{
+		customers:        make(map[string]int),
+		accounts:         make(map[string]int),
+		clients:          make(map[int]string),
+		numbers:          make(map[int]string),
+		customerAccounts: make([]models.CustomerAccountLinkRequest, 0),
+		nextID:           1,
+	}
+}
+
+// Handler returns the HTTP handler serving the mock endpoints
+func (api *MockAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Customer endpoints
+	mux.HandleFunc("/customers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			api.find(w, r, api.customers, "customer_number")
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req models.CustomerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		api.mu.Lock()
+		id := api.nextID
+		api.nextID++
+		api.customers[models.ScopedKey(req.ClientID, req.CustomerNumber)] = id
+		api.clients[id] = req.ClientID
+		api.numbers[id] = req.CustomerNumber
+		api.mu.Unlock()
+
+		log.Printf("Created customer %s with ID %d", req.CustomerNumber, id)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"id": id})
+	})
+
+	// Account endpoints
+	mux.HandleFunc("/accounts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			api.find(w, r, api.accounts, "account_number")
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req models.AccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		api.mu.Lock()
+		id := api.nextID
+		api.nextID++
+		api.accounts[models.ScopedKey(req.ClientID, req.AccountNumber)] = id
+		api.clients[id] = req.ClientID
+		api.numbers[id] = req.AccountNumber
+		api.mu.Unlock()
+
+		log.Printf("Created account %s with ID %d", req.AccountNumber, id)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"id": id})
+	})
+
+	// Customer-Account link endpoints
+	mux.HandleFunc("/customer-accounts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req models.CustomerAccountLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		api.mu.Lock()
+		api.customerAccounts = append(api.customerAccounts, req)
+		api.mu.Unlock()
+
+		log.Printf("Created link between customer %d and account %d", req.CustomerID, req.AccountID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	})
+
+	// Stats endpoint
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		stats := map[string]int{
+			"customers": len(api.customers),
+			"accounts":  len(api.accounts),
+			"links":     len(api.customerAccounts),
+		}
+		api.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+
+	return mux
+}
+
+// find answers a lookup such as GET /customers?customer_number=A&customer_number=B
+// with the matching records of client_id, or of every client if not given
+func (api *MockAPI) find(w http.ResponseWriter, r *http.Request, ids map[string]int, param string) {
+	query := r.URL.Query()
+	client := query.Get("client_id")
+
+	api.mu.Lock()
+	records := make([]map[string]interface{}, 0)
+	for _, number := range query[param] {
+		var found []int
+		if client != "" {
+			if id, ok := ids[models.ScopedKey(client, number)]; ok {
+				found = append(found, id)
+			}
+		} else {
+			for _, id := range ids {
+				if api.numbers[id] == number {
+					found = append(found, id)
+				}
+			}
+		}
+		for _, id := range found {
+			records = append(records, map[string]interface{}{"id": id, param: number, "client_id": api.clients[id]})
+		}
+	}
+	api.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// ListenAndServe runs a new mock API on addr until the server fails
+func ListenAndServe(addr string) error {
+	log.Printf("Starting mock API server on %s", addr)
+	return http.ListenAndServe(addr, NewMockAPI().Handler())
+}
@@ -10,16 +10,23 @@ type CustomerRequest struct {
 	Address        string `json:"address,omitempty"` // Optional
 	Name           string `json:"name,omitempty"`    // Optional
 	Email          string `json:"email,omitempty"`   // Optional
+
+	Attributes map[string]string `json:"attributes,omitempty"` // Extra sheet columns
 }
 
 type AccountRequest struct {
-	AccountNumber string `json:"account_number"` // Required
-	AccountName   string `json:"account_name"`   // Required
+	ClientID      string `json:"client_id,omitempty"` // Set in tenant mode
+	AccountNumber string `json:"account_number"`      // Required
+	AccountName   string `json:"account_name"`        // Required
+
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
 type CustomerAccountLinkRequest struct {
 	CustomerID int `json:"customer_id"` // Required
 	AccountID  int `json:"account_id"`  // Required
+
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
 // Validation Error
@@ -41,20 +48,24 @@ func ToCustomerRequest(c Customer) CustomerRequest {
 		Address:        emptyToNil(c.Address),
 		Name:           emptyToNil(c.Name),
 		Email:          emptyToNil(c.Email),
+		Attributes:     c.Attributes,
 	}
 }
 
 func ToAccountRequest(a Account) AccountRequest {
 	return AccountRequest{
+		ClientID:      a.Tenant,
 		AccountNumber: a.AccountNumber,
 		AccountName:   a.AccountName,
+		Attributes:    a.Attributes,
 	}
 }
 
-func ToLinkRequest(customerID, accountID int) CustomerAccountLinkRequest {
+func ToLinkRequest(l CustomerAccount, customerID, accountID int) CustomerAccountLinkRequest {
 	return CustomerAccountLinkRequest{
 		CustomerID: customerID,
 		AccountID:  accountID,
+		Attributes: l.Attributes,
 	}
 }
 
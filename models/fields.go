@@ -0,0 +1,80 @@
+package models
+
+import "sort"
+
+// Field is a single named value of a row, used when comparing or hashing rows
+type Field struct {
+	Name  string
+	Value string
+}
+
+// ScopedKey qualifies a customer or account number with its tenant. Outside
+// tenant mode the tenant is empty and the key is the number itself.
+func ScopedKey(tenant, number string) string {
+	if tenant == "" {
+		return number
+	}
+	return tenant + "/" + number
+}
+
+// Key returns the natural key of a customer
+func (c Customer) Key() string {
+	return ScopedKey(c.Tenant, c.CustomerNumber)
+}
+
+// Fields returns the non-key fields of a customer in sheet order
+func (c Customer) Fields() []Field {
+	return []Field{
+		{Name: "client_id", Value: c.ClientID},
+		{Name: "customer_name", Value: c.CustomerName},
+		{Name: "address", Value: c.Address},
+		{Name: "name", Value: c.Name},
+		{Name: "email", Value: c.Email},
+	}
+}
+
+// Key returns the natural key of an account
+func (a Account) Key() string {
+	return ScopedKey(a.Tenant, a.AccountNumber)
+}
+
+// Fields returns the non-key fields of an account in sheet order
+func (a Account) Fields() []Field {
+	return []Field{
+		{Name: "account_name", Value: a.AccountName},
+	}
+}
+
+// Key returns the natural key of a customer-account link
+func (l CustomerAccount) Key() string {
+	return ScopedKey(l.Tenant, l.CustomerNumber+"|"+l.AccountNumber)
+}
+
+// CustomerKey returns the key of the linked customer
+func (l CustomerAccount) CustomerKey() string {
+	return ScopedKey(l.Tenant, l.CustomerNumber)
+}
+
+// AccountKey returns the key of the linked account
+func (l CustomerAccount) AccountKey() string {
+	return ScopedKey(l.Tenant, l.AccountNumber)
+}
+
+// Key returns the scoped key, as used in ID maps
+func (k NaturalKey) Key() string {
+	return ScopedKey(k.Tenant, k.Number)
+}
+
+// AttributePrefix starts the field names of attributes
+const AttributePrefix = "attr:"
+
+// AttributeFields returns attributes as fields sorted by name, so rows can be
+// hashed or compared independently of map order
+func AttributeFields(attrs map[string]string) []Field {
+	fields := make([]Field, 0, len(attrs))
+	for name, value := range attrs {
+		fields = append(fields, Field{Name: AttributePrefix + name, Value: value})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Customer struct {
 	ClientID       string
 	CustomerNumber string
@@ -7,16 +9,27 @@ type Customer struct {
 	Address        string
 	Name           string
 	Email          string
+	Tenant         string            // Scope of CustomerNumber in tenant mode, empty otherwise
+	Attributes     map[string]string // Extra sheet columns by header, nil when there are none
+	Row            int               // Sheet row the customer was read from, 0 if not read from a sheet
 }
 
 type Account struct {
+	ClientID      string // Optional, used to scope the account in tenant mode
 	AccountNumber string
 	AccountName   string
+	Tenant        string
+	Attributes    map[string]string
+	Row           int
 }
 
 type CustomerAccount struct {
+	ClientID       string // Optional, used to scope the link in tenant mode
 	CustomerNumber string
 	AccountNumber  string
+	Tenant         string
+	Attributes     map[string]string
+	Row            int
 }
 
 // Repository interfaces for database operations
@@ -29,6 +42,73 @@ type AccountRepository interface {
 	InsertAccounts(accounts []Account) (map[string]int, error)
 }
 
+// CustomerAccountRepository writes links and returns how many were written:
+// links whose customer or account has no ID are skipped
 type CustomerAccountRepository interface {
-	InsertCustomerAccounts(links []CustomerAccount, customerIDs, accountIDs map[string]int) error
+	InsertCustomerAccounts(links []CustomerAccount, customerIDs, accountIDs map[string]int) (int, error)
+}
+
+// ParallelLoader is implemented by repositories whose InsertCustomers and
+// InsertAccounts may run concurrently
+type ParallelLoader interface {
+	SupportsParallelLoad() bool
+}
+
+// IDResolver is implemented by repositories that can look up the IDs of
+// customers and accounts already in the target. Keys not found are left out
+// of the returned maps, which are keyed like Customer.Key and Account.Key.
+type IDResolver interface {
+	ResolveCustomerIDs(keys []NaturalKey) (map[string]int, error)
+	ResolveAccountIDs(keys []NaturalKey) (map[string]int, error)
+}
+
+// NaturalKey is a customer or account number along with its tenant
+type NaturalKey struct {
+	Tenant string
+	Number string
+}
+
+// Exporter is implemented by repositories that can read back everything they hold
+type Exporter interface {
+	ExportWorkbook() (*Workbook, error)
+}
+
+// Workbook holds the rows read from the three sheets of an import file
+type Workbook struct {
+	Customers []Customer
+	Accounts  []Account
+	Links     []CustomerAccount
+}
+
+// Import run statuses
+const (
+	RunRunning    = "running"
+	RunSucceeded  = "succeeded"
+	RunFailed     = "failed"
+	RunRolledBack = "rolled_back"
+)
+
+// ImportRun is one entry of the import run ledger
+type ImportRun struct {
+	RunID         string
+	FileName      string
+	FileSHA256    string
+	Operator      string
+	Backend       string
+	StartedAt     time.Time
+	FinishedAt    *time.Time
+	CustomerCount int
+	AccountCount  int
+	LinkCount     int
+	EntityCounts  map[string]int // By entity name, for runs of entity definitions
+	Status        string
+	ErrorSummary  string
+}
+
+// RunLedger is implemented by repositories that record import runs and
+// stamp the rows they write with the current run ID
+type RunLedger interface {
+	BeginRun(run *ImportRun) error
+	FinishRun(run *ImportRun) error
+	ListRuns(limit int) ([]ImportRun, error)
 }
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Sheet names of the import workbook
+const (
+	CustomersSheet = "Customers"
+	AccountsSheet  = "Account"
+	LinksSheet     = "customer account link"
+)
+
+// RowError is a validation failure on a specific sheet row
+type RowError struct {
+	Sheet string
+	Row   int
+	ValidationError
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("%s row %d: %s", e.Sheet, e.Row, e.ValidationError.Error())
+}
+
+// ValidationErrors collects every row that failed validation
+type ValidationErrors []RowError
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d validation errors, first: %s", len(e), e[0].Error())
+}
+
+// Validate checks required fields and the column lengths of the target schema
+func (c Customer) Validate() []ValidationError {
+	var errs []ValidationError
+	errs = required(errs, "client_id", c.ClientID)
+	errs = required(errs, "customer_number", c.CustomerNumber)
+	errs = required(errs, "customer_name", c.CustomerName)
+	errs = maxLength(errs, "client_id", c.ClientID, 50)
+	errs = maxLength(errs, "customer_number", c.CustomerNumber, 50)
+	errs = maxLength(errs, "customer_name", c.CustomerName, 255)
+	errs = maxLength(errs, "name", c.Name, 255)
+	errs = maxLength(errs, "email", c.Email, 255)
+	return errs
+}
+
+// Validate checks required fields and the column lengths of the target schema
+func (a Account) Validate() []ValidationError {
+	var errs []ValidationError
+	errs = required(errs, "account_number", a.AccountNumber)
+	errs = required(errs, "account_name", a.AccountName)
+	errs = maxLength(errs, "client_id", a.ClientID, 50)
+	errs = maxLength(errs, "account_number", a.AccountNumber, 50)
+	errs = maxLength(errs, "account_name", a.AccountName, 255)
+	return errs
+}
+
+// Validate checks that both sides of the link are present
+func (l CustomerAccount) Validate() []ValidationError {
+	var errs []ValidationError
+	errs = required(errs, "customer_number", l.CustomerNumber)
+	errs = required(errs, "account_number", l.AccountNumber)
+	errs = maxLength(errs, "client_id", l.ClientID, 50)
+	return errs
+}
+
+// Validate checks every row of the workbook, returning nil when all rows are valid
+func (wb *Workbook) Validate() ValidationErrors {
+	var errs ValidationErrors
+	for _, c := range wb.Customers {
+		for _, e := range c.Validate() {
+			errs = append(errs, RowError{Sheet: CustomersSheet, Row: c.Row, ValidationError: e})
+		}
+	}
+	for _, a := range wb.Accounts {
+		for _, e := range a.Validate() {
+			errs = append(errs, RowError{Sheet: AccountsSheet, Row: a.Row, ValidationError: e})
+		}
+	}
+	for _, l := range wb.Links {
+		for _, e := range l.Validate() {
+			errs = append(errs, RowError{Sheet: LinksSheet, Row: l.Row, ValidationError: e})
+		}
+	}
+	return errs
+}
+
+func required(errs []ValidationError, field, value string) []ValidationError {
+	if value == "" {
+		errs = append(errs, ValidationError{Field: field, Message: "is required"})
+	}
+	return errs
+}
+
+func maxLength(errs []ValidationError, field, value string, max int) []ValidationError {
+	if utf8.RuneCountInString(value) > max {
+		errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf("exceeds %d characters", max)})
+	}
+	return errs
+}
@@ -0,0 +1,120 @@
+package normalize
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Address is a free-text postal address split into its components. Parts
+// that could not be recognized are empty.
+type Address struct {
+	Street   string // Including the house number and any suite, unit or flat
+	City     string
+	Region   string // State or province code, e.g. IL or ON
+	Postcode string
+	Country  string
+}
+
+// Postcode formats, most specific first so a US ZIP is not taken for part
+// of a Canadian or UK code
+var postcodePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}\b`), // UK: SW1A 1AA
+	regexp.MustCompile(`(?i)\b[A-Z]\d[A-Z] ?\d[A-Z]\d\b`),          // Canada: K1A 0B1
+	regexp.MustCompile(`\b\d{4} ?[A-Z]{2}\b`),                      // Netherlands: 1012 AB
+	regexp.MustCompile(`\b\d{5}(?:-\d{4})?\b`),                     // US ZIP: 62704, 62704-1234
+	regexp.MustCompile(`\b\d{4,6}\b`),                              // Most others: 75001, 2000
+}
+
+var (
+	unitPattern   = regexp.MustCompile(`(?i)^(suite|ste\.?|apt\.?|apartment|unit|flat|floor|fl\.?|room|rm\.?|building|bldg\.?|#)\s*\S`)
+	regionPattern = regexp.MustCompile(`^[A-Z]{2,3}$`)
+	spaces        = regexp.MustCompile(`\s+`)
+)
+
+// ParseAddress splits a comma-separated address such as
+// "10 Main St, Suite 4, Springfield, IL 62704" or "1 High St, London SW1A 1AA".
+// The first part, with any suite or unit parts next to it, is the street; a
+// trailing country is recognized from a bundled list; a postcode is looked
+// for from the end, outside the street; the last remaining part is the city,
+// after a trailing region code.
+func ParseAddress(s string) Address {
+	var parts []string
+	for _, p := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' || r == ';' }) {
+		if p = strings.TrimSpace(spaces.ReplaceAllString(p, " ")); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return Address{}
+	}
+
+	var addr Address
+	street := []string{parts[0]}
+	rest := parts[1:]
+	for len(rest) > 0 && (unitPattern.MatchString(rest[0]) || unitPattern.MatchString(street[len(street)-1]) && startsWithDigit(rest[0])) {
+		street = append(street, rest[0])
+		rest = rest[1:]
+	}
+	addr.Street = strings.Join(street, ", ")
+
+	if len(rest) == 0 {
+		// A single part may still end in a postcode
+		if code, before := cutPostcode(addr.Street, true); code != "" && before != "" {
+			addr.Street, addr.Postcode = before, code
+		}
+		return addr
+	}
+
+	if n := len(rest); countryNames[strings.ToLower(rest[n-1])] {
+		addr.Country = rest[n-1]
+		rest = rest[:n-1]
+	}
+
+	for i := len(rest) - 1; i >= 0 && addr.Postcode == ""; i-- {
+		if code, remainder := cutPostcode(rest[i], false); code != "" {
+			addr.Postcode = code
+			if remainder == "" {
+				rest = append(rest[:i], rest[i+1:]...)
+			} else {
+				rest[i] = remainder
+			}
+		}
+	}
+
+	// A region code may stand alone or be what was left next to the postcode
+	if n := len(rest); n > 0 && regionPattern.MatchString(rest[n-1]) {
+		addr.Region = rest[n-1]
+		rest = rest[:n-1]
+	} else if n > 0 {
+		if i := strings.LastIndex(rest[n-1], " "); i > 0 && regionPattern.MatchString(rest[n-1][i+1:]) {
+			addr.Region = rest[n-1][i+1:]
+			rest[n-1] = rest[n-1][:i]
+		}
+	}
+	if len(rest) > 0 {
+		addr.City = rest[len(rest)-1]
+	}
+	return addr
+}
+
+// cutPostcode finds the last postcode in a part, returning it upper-cased
+// along with the rest of the part. With atEnd the postcode must end the part.
+func cutPostcode(part string, atEnd bool) (code, remainder string) {
+	for _, re := range postcodePatterns {
+		matches := re.FindAllStringIndex(part, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		m := matches[len(matches)-1]
+		if atEnd && m[1] != len(part) {
+			continue
+		}
+		remainder = strings.TrimSpace(part[:m[0]] + " " + part[m[1]:])
+		return strings.ToUpper(part[m[0]:m[1]]), spaces.ReplaceAllString(remainder, " ")
+	}
+	return "", part
+}
+
+func startsWithDigit(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
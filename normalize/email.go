@@ -0,0 +1,133 @@
+// Package normalize cleans up and classifies email addresses and free-text
+// postal addresses without any network lookups.
+package normalize
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Email is a normalized email address
+type Email struct {
+	Address    string // local@domain, the domain in lower case and the local part quoted if it must be
+	Local      string // Unquoted
+	Domain     string
+	Disposable bool // The domain belongs to a disposable email service
+	Role       bool // The local part names a function, e.g. info or sales
+}
+
+// NormalizeEmail strips whitespace and any display name, checks the RFC 5322
+// syntax and lower-cases the domain. The local part keeps its case since
+// it may be significant to the receiving server.
+func NormalizeEmail(s string) (Email, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Email{}, fmt.Errorf("empty email address")
+	}
+
+	parsed, err := mail.ParseAddress(s)
+	if err != nil {
+		return Email{}, fmt.Errorf("invalid email address %q: %v", s, err)
+	}
+
+	at := strings.LastIndex(parsed.Address, "@")
+	if at < 0 {
+		return Email{}, fmt.Errorf("invalid email address %q: missing @", s)
+	}
+	local := parsed.Address[:at]
+	domain := strings.ToLower(strings.TrimSuffix(parsed.Address[at+1:], "."))
+	if err := checkDomain(domain); err != nil {
+		return Email{}, fmt.Errorf("invalid email address %q: %v", s, err)
+	}
+
+	return Email{
+		Address:    quoteLocal(local) + "@" + domain,
+		Local:      local,
+		Domain:     domain,
+		Disposable: isDisposable(domain),
+		Role:       isRole(local),
+	}, nil
+}
+
+// quoteLocal quotes a local part that is not a dot-atom, such as "a b", as
+// net/mail does when formatting an address
+func quoteLocal(local string) string {
+	if isDotAtom(local) {
+		return local
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range local {
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// isDotAtom reports whether s is made of atext separated by single dots
+func isDotAtom(s string) bool {
+	if s == "" || strings.HasPrefix(s, ".") || strings.HasSuffix(s, ".") || strings.Contains(s, "..") {
+		return false
+	}
+	for _, r := range s {
+		if r != '.' && !isAtext(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isAtext reports whether r may appear unquoted in a local part, including
+// UTF-8 as in RFC 6532
+func isAtext(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r >= 0x80:
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", r)
+}
+
+// checkDomain requires a dotted host name, since addresses of bare hosts are
+// syntactically valid but never what a sheet meant
+func checkDomain(domain string) error {
+	if strings.HasPrefix(domain, "[") {
+		return fmt.Errorf("address literal domains are not accepted")
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("domain %q has no top-level domain", domain)
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("invalid domain %q", domain)
+		}
+	}
+	return nil
+}
+
+// isDisposable reports whether the domain, or a parent domain, is a known
+// disposable email service
+func isDisposable(domain string) bool {
+	for {
+		if disposableDomains[domain] {
+			return true
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+// isRole reports whether the local part, ignoring a +tag, is a role name
+func isRole(local string) bool {
+	if plus := strings.Index(local, "+"); plus > 0 {
+		local = local[:plus]
+	}
+	return roleLocalParts[strings.ToLower(local)]
+}
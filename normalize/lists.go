@@ -0,0 +1,32 @@
+package normalize
+
+import (
+	_ "embed"
+	"strings"
+)
+
+var (
+	//go:embed disposable.txt
+	disposableList string
+	//go:embed role.txt
+	roleList string
+	//go:embed countries.txt
+	countryList string
+
+	disposableDomains = parseList(disposableList)
+	roleLocalParts    = parseList(roleList)
+	countryNames      = parseList(countryList)
+)
+
+// parseList reads a bundled list of lower-case entries, skipping comments
+func parseList(text string) map[string]bool {
+	entries := make(map[string]bool)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries[strings.ToLower(line)] = true
+	}
+	return entries
+}
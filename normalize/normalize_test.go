@@ -0,0 +1,148 @@
+package normalize
+
+import (
+	"net/mail"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		in         string
+		address    string
+		local      string
+		domain     string
+		disposable bool
+		role       bool
+	}{
+		{in: "jane.doe@example.com", address: "jane.doe@example.com", local: "jane.doe", domain: "example.com"},
+		{in: "  jane.doe@example.com\t", address: "jane.doe@example.com", local: "jane.doe", domain: "example.com"},
+		{in: "Jane.Doe@Example.COM", address: "Jane.Doe@example.com", local: "Jane.Doe", domain: "example.com"},
+
+		// Display names
+		{in: "Jane Doe <jane@example.com>", address: "jane@example.com", local: "jane", domain: "example.com"},
+		{in: `"Doe, Jane" <Jane@EXAMPLE.com>`, address: "Jane@example.com", local: "Jane", domain: "example.com"},
+
+		// Quoted local parts
+		{in: `"a b"@x.org`, address: `"a b"@x.org`, local: "a b", domain: "x.org"},
+		{in: `"a\"b"@x.org`, address: `"a\"b"@x.org`, local: `a"b`, domain: "x.org"},
+		{in: `"a..b"@x.org`, address: `"a..b"@x.org`, local: "a..b", domain: "x.org"},
+		{in: `"jane.doe"@x.org`, address: "jane.doe@x.org", local: "jane.doe", domain: "x.org"},
+		{in: "jöhn@x.org", address: "jöhn@x.org", local: "jöhn", domain: "x.org"},
+
+		// Disposable domains, including subdomains
+		{in: "x@mailinator.com", address: "x@mailinator.com", local: "x", domain: "mailinator.com", disposable: true},
+		{in: "x@MAILINATOR.COM", address: "x@mailinator.com", local: "x", domain: "mailinator.com", disposable: true},
+		{in: "x@eu.yopmail.com", address: "x@eu.yopmail.com", local: "x", domain: "eu.yopmail.com", disposable: true},
+		{in: "x@notmailinator.com", address: "x@notmailinator.com", local: "x", domain: "notmailinator.com"},
+
+		// Role local parts, ignoring case and a +tag
+		{in: "info@example.com", address: "info@example.com", local: "info", domain: "example.com", role: true},
+		{in: "Sales@example.com", address: "Sales@example.com", local: "Sales", domain: "example.com", role: true},
+		{in: "support+eu@example.com", address: "support+eu@example.com", local: "support+eu", domain: "example.com", role: true},
+		{in: "no-reply@example.com", address: "no-reply@example.com", local: "no-reply", domain: "example.com", role: true},
+		{in: "information@example.com", address: "information@example.com", local: "information", domain: "example.com"},
+		{in: "info@yopmail.com", address: "info@yopmail.com", local: "info", domain: "yopmail.com", disposable: true, role: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := NormalizeEmail(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Email{Address: tt.address, Local: tt.local, Domain: tt.domain, Disposable: tt.disposable, Role: tt.role}
+			if got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+			// The normalized address must itself be valid
+			if _, err := mail.ParseAddress(got.Address); err != nil {
+				t.Errorf("normalized address %q does not parse: %v", got.Address, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeEmailErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"   ",
+		"jane",
+		"jane@",
+		"@example.com",
+		"jane@@example.com",
+		"jane doe@example.com",
+		"jane@localhost",
+		"jane@[192.0.2.1]",
+		"jane@-example.com",
+		"jane@example-.com",
+		"jane@example..com",
+	} {
+		t.Run(in, func(t *testing.T) {
+			if got, err := NormalizeEmail(in); err == nil {
+				t.Errorf("got %+v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Address
+	}{
+		// The samples of the ParseAddress doc comment
+		{
+			in:   "10 Main St, Suite 4, Springfield, IL 62704",
+			want: Address{Street: "10 Main St, Suite 4", City: "Springfield", Region: "IL", Postcode: "62704"},
+		},
+		{
+			in:   "1 High St, London SW1A 1AA",
+			want: Address{Street: "1 High St", City: "London", Postcode: "SW1A 1AA"},
+		},
+
+		{
+			in:   "10 Main St, Springfield, IL, 62704-1234, USA",
+			want: Address{Street: "10 Main St", City: "Springfield", Region: "IL", Postcode: "62704-1234", Country: "USA"},
+		},
+		{
+			in:   "24 Sussex Dr, Ottawa ON K1A 0B1, Canada",
+			want: Address{Street: "24 Sussex Dr", City: "Ottawa", Region: "ON", Postcode: "K1A 0B1", Country: "Canada"},
+		},
+		{
+			in:   "Damrak 1, 1012 AB Amsterdam, Netherlands",
+			want: Address{Street: "Damrak 1", City: "Amsterdam", Postcode: "1012 AB", Country: "Netherlands"},
+		},
+		{
+			in:   "5 Rue de Rivoli, 75001 Paris, France",
+			want: Address{Street: "5 Rue de Rivoli", City: "Paris", Postcode: "75001", Country: "France"},
+		},
+		{
+			in:   "1 high st,  london   sw1a 1aa, uk",
+			want: Address{Street: "1 high st", City: "london", Postcode: "SW1A 1AA", Country: "uk"},
+		},
+		{
+			in:   "Flat 2, 7 Park Lane, Leeds",
+			want: Address{Street: "Flat 2, 7 Park Lane", City: "Leeds"},
+		},
+		{
+			in:   "10 Main St\nSpringfield; IL 62704",
+			want: Address{Street: "10 Main St", City: "Springfield", Region: "IL", Postcode: "62704"},
+		},
+		{
+			in:   "PO Box 12 62704",
+			want: Address{Street: "PO Box 12", Postcode: "62704"},
+		},
+		{
+			in:   "10 Main St",
+			want: Address{Street: "10 Main St"},
+		},
+		{in: "", want: Address{}},
+		{in: " , ,", want: Address{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := ParseAddress(tt.in); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package transform
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Policies for values missing from a lookup table
+const (
+	UnmappedPass    = "pass"    // Keep the value
+	UnmappedDefault = "default" // Replace it by the step's value
+	UnmappedReject  = "reject"  // Reject the row
+)
+
+// Table translates codes, e.g. partner region codes to ours. Its first
+// column holds the codes and its second the translations.
+type Table struct {
+	Source string
+	values map[string]string
+	loaded bool
+}
+
+// NewTable returns a table of the given translations
+func NewTable(source string, values map[string]string) *Table {
+	return &Table{Source: source, values: values, loaded: true}
+}
+
+// LoadCSV reads a table from a CSV file with a header row
+func LoadCSV(filename string) (*Table, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lookup table: %v", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lookup table %s: %v", filename, err)
+	}
+	t := &Table{Source: filename}
+	if err := t.Fill(rows); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Fill sets the translations from rows of cells, the first being a header
+func (t *Table) Fill(rows [][]string) error {
+	t.values = make(map[string]string)
+	for i, row := range rows {
+		if i == 0 || isBlank(row) {
+			continue
+		}
+		if len(row) < 2 {
+			return fmt.Errorf("lookup table %s: row %d has no translation", t.Source, i+1)
+		}
+		code, translation := strings.TrimSpace(row[0]), strings.TrimSpace(row[1])
+		if previous, ok := t.values[code]; ok && previous != translation {
+			return fmt.Errorf("lookup table %s: code %q is translated twice", t.Source, code)
+		}
+		t.values[code] = translation
+	}
+	t.loaded = true
+	return nil
+}
+
+func isBlank(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Rejection is returned by a step that rejects the whole row
+type Rejection struct {
+	Reason string
+}
+
+func (r Rejection) Error() string {
+	return r.Reason
+}
+
+// Lookup translates values found in table. Values that are not found are
+// kept, replaced by value or rejected according to unmapped; blank values
+// are only replaced, never rejected.
+func Lookup(table *Table, unmapped, value string) (Func, error) {
+	switch unmapped {
+	case "", UnmappedPass, UnmappedDefault, UnmappedReject:
+	default:
+		return nil, fmt.Errorf("unknown unmapped policy %q, expected pass, default or reject", unmapped)
+	}
+	return func(v string, _ map[string]string) (string, error) {
+		if !table.loaded {
+			return "", fmt.Errorf("lookup table %s is not loaded", table.Source)
+		}
+		if translated, ok := table.values[v]; ok {
+			return translated, nil
+		}
+		if strings.TrimSpace(v) == "" && unmapped == UnmappedReject {
+			return v, nil
+		}
+		switch unmapped {
+		case UnmappedDefault:
+			return value, nil
+		case UnmappedReject:
+			return "", Rejection{Reason: fmt.Sprintf("%q is not in lookup table %s", v, table.Source)}
+		}
+		return v, nil
+	}, nil
+}
@@ -0,0 +1,187 @@
+package transform
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFill(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    [][]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "header skipped",
+			rows: [][]string{{"Code", "Region"}, {"N", "North"}, {"S", "South"}},
+			want: map[string]string{"N": "North", "S": "South"},
+		},
+		{
+			name: "trimmed",
+			rows: [][]string{{"Code", "Region"}, {" N ", " North\t"}},
+			want: map[string]string{"N": "North"},
+		},
+		{
+			name: "blank rows skipped",
+			rows: [][]string{{"Code", "Region"}, {"", " "}, {}, {"N", "North"}},
+			want: map[string]string{"N": "North"},
+		},
+		{
+			name: "extra columns ignored",
+			rows: [][]string{{"Code", "Region", "Note"}, {"N", "North", "old"}},
+			want: map[string]string{"N": "North"},
+		},
+		{
+			name: "repeated row",
+			rows: [][]string{{"Code", "Region"}, {"N", "NORTH"}, {"N", "NORTH "}},
+			want: map[string]string{"N": "NORTH"},
+		},
+		{
+			name:    "translated twice",
+			rows:    [][]string{{"Code", "Region"}, {"N", "North"}, {"N", "Nord"}},
+			wantErr: true,
+		},
+		{
+			name:    "no translation",
+			rows:    [][]string{{"Code", "Region"}, {"N"}},
+			wantErr: true,
+		},
+		{
+			name: "header only",
+			rows: [][]string{{"Code", "Region"}},
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &Table{Source: "test"}
+			err := table.Fill(tt.rows)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", table.values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !table.loaded {
+				t.Error("table not marked loaded")
+			}
+			if !reflect.DeepEqual(table.values, tt.want) {
+				t.Errorf("got %v, want %v", table.values, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		want     map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "table",
+			filename: write("regions.csv", "code,region\nN,North\nS,\"South, coast\"\n\nE,East\n"),
+			want:     map[string]string{"N": "North", "S": "South, coast", "E": "East"},
+		},
+		{
+			name:     "ragged rows",
+			filename: write("ragged.csv", "code,region,note\nN,North\nS,South,new\n"),
+			want:     map[string]string{"N": "North", "S": "South"},
+		},
+		{
+			name:     "repeated row",
+			filename: write("repeated.csv", "code,region\nN,NORTH\nN,NORTH \n"),
+			want:     map[string]string{"N": "NORTH"},
+		},
+		{name: "translated twice", filename: write("twice.csv", "code,region\nN,North\nN,Nord\n"), wantErr: true},
+		{name: "unterminated quote", filename: write("bad.csv", "code,region\nN,\"North\n"), wantErr: true},
+		{name: "missing file", filename: filepath.Join(dir, "none.csv"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := LoadCSV(tt.filename)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", table.values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if table.Source != tt.filename {
+				t.Errorf("source %q, want %q", table.Source, tt.filename)
+			}
+			if !reflect.DeepEqual(table.values, tt.want) {
+				t.Errorf("got %v, want %v", table.values, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupPolicies(t *testing.T) {
+	table := NewTable("regions", map[string]string{"N": "North"})
+
+	tests := []struct {
+		name     string
+		unmapped string
+		value    string
+		want     string
+		reject   bool
+	}{
+		{name: "mapped", unmapped: UnmappedReject, value: "N", want: "North"},
+		{name: "pass by default", unmapped: "", value: "E", want: "E"},
+		{name: "pass", unmapped: UnmappedPass, value: "E", want: "E"},
+		{name: "pass blank", unmapped: UnmappedPass, value: "", want: ""},
+		{name: "default", unmapped: UnmappedDefault, value: "E", want: "Other"},
+		{name: "default blank", unmapped: UnmappedDefault, value: " ", want: "Other"},
+		{name: "reject", unmapped: UnmappedReject, value: "E", reject: true},
+		{name: "reject is case-sensitive", unmapped: UnmappedReject, value: "n", reject: true},
+		{name: "reject keeps blank", unmapped: UnmappedReject, value: " ", want: " "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, err := Lookup(table, tt.unmapped, "Other")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := step(tt.value, nil)
+			var rejection Rejection
+			if tt.reject {
+				if !errors.As(err, &rejection) {
+					t.Fatalf("got %q, %v, want a rejection", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupUnknownPolicy(t *testing.T) {
+	if _, err := Lookup(NewTable("regions", nil), "drop", ""); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,105 @@
+package transform
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"importer/normalize"
+)
+
+// NormalizeEmail normalizes an email address and rejects syntactically
+// invalid ones. Disposable and role addresses are listed in the into field
+// "flags", if given, and the row is rejected if their flag is in reject.
+// Blank values are left to validation.
+func NormalizeEmail(into map[string]string, reject []string) (Func, error) {
+	if err := checkInto(into, "flags"); err != nil {
+		return nil, err
+	}
+	rejected := make(map[string]bool, len(reject))
+	for _, flag := range reject {
+		if flag != "disposable" && flag != "role" {
+			return nil, fmt.Errorf("unknown email flag %q, expected disposable or role", flag)
+		}
+		rejected[flag] = true
+	}
+
+	return func(value string, row map[string]string) (string, error) {
+		if strings.TrimSpace(value) == "" {
+			return value, nil
+		}
+		email, err := normalize.NormalizeEmail(value)
+		if err != nil {
+			return "", err
+		}
+
+		var flags []string
+		if email.Disposable {
+			flags = append(flags, "disposable")
+		}
+		if email.Role {
+			flags = append(flags, "role")
+		}
+		for _, flag := range flags {
+			if rejected[flag] {
+				return "", Rejection{Reason: fmt.Sprintf("%s is a %s address", email.Address, flag)}
+			}
+		}
+		if field, ok := into["flags"]; ok {
+			row[fieldName(row, field)] = strings.Join(flags, ",")
+		}
+		return email.Address, nil
+	}, nil
+}
+
+// ParseAddress splits a free-text address into the fields named in into by
+// component: street, city, region, postcode and country. The address itself
+// is kept, with its whitespace collapsed.
+func ParseAddress(into map[string]string) (Func, error) {
+	if len(into) == 0 {
+		return nil, fmt.Errorf("address needs the fields to parse into")
+	}
+	if err := checkInto(into, "street", "city", "region", "postcode", "country"); err != nil {
+		return nil, err
+	}
+
+	return func(value string, row map[string]string) (string, error) {
+		addr := normalize.ParseAddress(value)
+		components := map[string]string{
+			"street":   addr.Street,
+			"city":     addr.City,
+			"region":   addr.Region,
+			"postcode": addr.Postcode,
+			"country":  addr.Country,
+		}
+		for component, field := range into {
+			row[fieldName(row, field)] = components[component]
+		}
+		return strings.Join(strings.Fields(value), " "), nil
+	}, nil
+}
+
+// checkInto rejects components a step does not produce
+func checkInto(into map[string]string, components ...string) error {
+	var unknown []string
+	for component := range into {
+		if !contains(components, component) {
+			unknown = append(unknown, component)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown components %s, expected %s",
+			strings.Join(unknown, ", "), strings.Join(components, ", "))
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"importer/config"
+	"importer/models"
+)
+
+// Pipeline applies the rules of one sheet to rows given as maps of field
+// name to value
+type Pipeline struct {
+	rules []rule
+}
+
+type rule struct {
+	field string
+	steps []Func
+}
+
+// newPipeline compiles the rules of one sheet. Lookup tables read from sheets
+// of the imported workbook are added to sheets, to be filled once it is open.
+func newPipeline(rules []config.FieldTransform, sheets map[string]*Table) (*Pipeline, error) {
+	p := &Pipeline{}
+	for _, r := range rules {
+		if r.Field == "" {
+			return nil, fmt.Errorf("transform without a field")
+		}
+		compiled := rule{field: r.Field}
+		for i, s := range r.Steps {
+			step, err := newStep(s, sheets)
+			if err != nil {
+				return nil, fmt.Errorf("field %s, step %d: %v", r.Field, i+1, err)
+			}
+			compiled.steps = append(compiled.steps, step)
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+func newStep(s config.TransformStep, sheets map[string]*Table) (Func, error) {
+	switch s.Op {
+	case "trim":
+		return Trim(), nil
+	case "case":
+		return Case(s.Case)
+	case "replace":
+		return Replace(s.Pattern, s.With)
+	case "default":
+		return Default(s.Value), nil
+	case "lookup":
+		table, err := lookupTable(s, sheets)
+		if err != nil {
+			return nil, err
+		}
+		return Lookup(table, s.Unmapped, s.Value)
+	case "template":
+		return Template(s.Value)
+	case "email":
+		return NormalizeEmail(s.Into, s.Reject)
+	case "address":
+		return ParseAddress(s.Into)
+	}
+	return nil, fmt.Errorf("unknown op %q, expected trim, case, replace, default, lookup, template, email or address", s.Op)
+}
+
+// lookupTable returns the table of a lookup step
+func lookupTable(s config.TransformStep, sheets map[string]*Table) (*Table, error) {
+	sources := 0
+	for _, set := range []bool{s.Table != nil, s.File != "", s.Sheet != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("lookup needs exactly one of table, file or sheet")
+	}
+
+	switch {
+	case s.File != "":
+		return LoadCSV(s.File)
+	case s.Sheet != "":
+		if sheets[s.Sheet] == nil {
+			sheets[s.Sheet] = &Table{Source: fmt.Sprintf("sheet %q", s.Sheet)}
+		}
+		return sheets[s.Sheet], nil
+	}
+	return NewTable("inline", s.Table), nil
+}
+
+// Apply transforms row in place. A field that is not in the row, such as an
+// absent attribute, is transformed from an empty value. A failing step leaves
+// its field unchanged and is reported; a step rejecting the row stops the
+// pipeline and returns the reason.
+func (p *Pipeline) Apply(row map[string]string) (reject string, errs []models.ValidationError) {
+	for _, r := range p.rules {
+		field := fieldName(row, r.field)
+		value := row[field]
+		var err error
+		for _, step := range r.steps {
+			if value, err = step(value, row); err != nil {
+				break
+			}
+		}
+		var rejection Rejection
+		switch {
+		case errors.As(err, &rejection):
+			return fmt.Sprintf("%s: %s", r.field, rejection.Reason), errs
+		case err != nil:
+			errs = append(errs, models.ValidationError{Field: r.field, Message: err.Error()})
+		default:
+			row[field] = value
+		}
+	}
+	return "", errs
+}
+
+// fieldName returns the name under which row holds a field, matching
+// attribute headers case-insensitively
+func fieldName(row map[string]string, field string) string {
+	if _, ok := row[field]; ok {
+		return field
+	}
+	for name := range row {
+		if strings.EqualFold(name, field) {
+			return name
+		}
+	}
+	return field
+}
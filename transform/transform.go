@@ -0,0 +1,94 @@
+// Package transform applies declarative cleanup rules to the fields of
+// workbook rows between reading and validation.
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Func transforms the value of one field. row holds every field of the row
+// by name, for steps that combine fields.
+type Func func(value string, row map[string]string) (string, error)
+
+// Trim strips surrounding whitespace
+func Trim() Func {
+	return func(value string, _ map[string]string) (string, error) {
+		return strings.TrimSpace(value), nil
+	}
+}
+
+// Case converts the value to upper, lower or title case
+func Case(mode string) (Func, error) {
+	var convert func(string) string
+	switch strings.ToLower(mode) {
+	case "upper":
+		convert = strings.ToUpper
+	case "lower":
+		convert = strings.ToLower
+	case "title":
+		convert = titleCase
+	default:
+		return nil, fmt.Errorf("unknown case %q, expected upper, lower or title", mode)
+	}
+	return func(value string, _ map[string]string) (string, error) {
+		return convert(value), nil
+	}, nil
+}
+
+// titleCase upper-cases the first letter of every word and lower-cases the rest
+func titleCase(s string) string {
+	runes := []rune(s)
+	start := true
+	for i, r := range runes {
+		if start {
+			runes[i] = unicode.ToUpper(r)
+		} else {
+			runes[i] = unicode.ToLower(r)
+		}
+		start = unicode.IsSpace(r) || r == '-'
+	}
+	return string(runes)
+}
+
+// Replace replaces every match of a regular expression. The replacement may
+// refer to groups as $1 or ${name}.
+func Replace(pattern, with string) (Func, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+	return func(value string, _ map[string]string) (string, error) {
+		return re.ReplaceAllString(value, with), nil
+	}, nil
+}
+
+// Default replaces blank values
+func Default(value string) Func {
+	return func(v string, _ map[string]string) (string, error) {
+		if strings.TrimSpace(v) == "" {
+			return value, nil
+		}
+		return v, nil
+	}
+}
+
+// Template replaces the value by a text/template executed over the row's
+// fields, e.g. "{{.name}} ({{.customer_number}})". Missing fields are empty.
+func Template(text string) (Func, error) {
+	tmpl, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	return func(_ string, row map[string]string) (string, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, row); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}, nil
+}
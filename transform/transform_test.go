@@ -0,0 +1,205 @@
+package transform
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"importer/config"
+)
+
+func TestSteps(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "regions.csv")
+	if err := os.WriteFile(csvFile, []byte("code,region\nN,North\nS,South\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	regions := map[string]string{"N": "North", "S": "South"}
+	sheet := [][]string{{"Code", "Region"}, {"N", "North"}, {"S", "South"}}
+
+	tests := []struct {
+		name   string
+		step   config.TransformStep
+		sheet  [][]string // Rows of the lookup sheet, if the step reads one
+		row    map[string]string
+		value  string
+		want   string
+		reject bool
+	}{
+		{name: "trim", step: config.TransformStep{Op: "trim"}, value: "  Acme Ltd \t", want: "Acme Ltd"},
+		{name: "trim blank", step: config.TransformStep{Op: "trim"}, value: "   ", want: ""},
+		{name: "case upper", step: config.TransformStep{Op: "case", Case: "upper"}, value: "gb", want: "GB"},
+		{name: "case lower", step: config.TransformStep{Op: "case", Case: "lower"}, value: "Info@Acme.COM", want: "info@acme.com"},
+		{name: "case title", step: config.TransformStep{Op: "case", Case: "title"}, value: "jean-luc PICARD", want: "Jean-Luc Picard"},
+		{name: "replace", step: config.TransformStep{Op: "replace", Pattern: `[^0-9]`, With: ""}, value: "+44 (20) 7946-0000", want: "442079460000"},
+		{name: "replace groups", step: config.TransformStep{Op: "replace", Pattern: `^(\w+), (\w+)$`, With: "$2 $1"}, value: "Doe, Jane", want: "Jane Doe"},
+		{name: "default blank", step: config.TransformStep{Op: "default", Value: "n/a"}, value: " ", want: "n/a"},
+		{name: "default set", step: config.TransformStep{Op: "default", Value: "n/a"}, value: "x", want: "x"},
+
+		{name: "lookup inline", step: config.TransformStep{Op: "lookup", Table: regions}, value: "N", want: "North"},
+		{name: "lookup csv", step: config.TransformStep{Op: "lookup", File: csvFile}, value: "S", want: "South"},
+		{name: "lookup sheet", step: config.TransformStep{Op: "lookup", Sheet: "Regions"}, sheet: sheet, value: "N", want: "North"},
+		{name: "unmapped pass", step: config.TransformStep{Op: "lookup", Table: regions}, value: "E", want: "E"},
+		{name: "unmapped pass explicit", step: config.TransformStep{Op: "lookup", File: csvFile, Unmapped: UnmappedPass}, value: "E", want: "E"},
+		{name: "unmapped default", step: config.TransformStep{Op: "lookup", Table: regions, Unmapped: UnmappedDefault, Value: "Other"}, value: "E", want: "Other"},
+		{name: "unmapped default blank", step: config.TransformStep{Op: "lookup", Sheet: "Regions", Unmapped: UnmappedDefault, Value: "Other"}, sheet: sheet, value: "", want: "Other"},
+		{name: "unmapped reject", step: config.TransformStep{Op: "lookup", Table: regions, Unmapped: UnmappedReject}, value: "E", reject: true},
+		{name: "unmapped reject csv", step: config.TransformStep{Op: "lookup", File: csvFile, Unmapped: UnmappedReject}, value: "W", reject: true},
+		{name: "unmapped reject sheet", step: config.TransformStep{Op: "lookup", Sheet: "Regions", Unmapped: UnmappedReject}, sheet: sheet, value: "W", reject: true},
+		{name: "unmapped reject keeps blank", step: config.TransformStep{Op: "lookup", Table: regions, Unmapped: UnmappedReject}, value: "", want: ""},
+
+		{name: "template", step: config.TransformStep{Op: "template", Value: "{{.name}} ({{.customer_number}})"},
+			row: map[string]string{"name": "Acme", "customer_number": "C1"}, want: "Acme (C1)"},
+		{name: "template missing field", step: config.TransformStep{Op: "template", Value: "{{.name}}/{{.region}}"},
+			row: map[string]string{"name": "Acme"}, want: "Acme/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sheets := make(map[string]*Table)
+			step, err := newStep(tt.step, sheets)
+			if err != nil {
+				t.Fatalf("newStep: %v", err)
+			}
+			if tt.sheet != nil {
+				if err := sheets[tt.step.Sheet].Fill(tt.sheet); err != nil {
+					t.Fatalf("Fill: %v", err)
+				}
+			}
+			row := tt.row
+			if row == nil {
+				row = map[string]string{}
+			}
+
+			got, err := step(tt.value, row)
+			var rejection Rejection
+			if tt.reject {
+				if !errors.As(err, &rejection) {
+					t.Fatalf("got %q, %v, want a rejection", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStepErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		step config.TransformStep
+	}{
+		{name: "unknown op", step: config.TransformStep{Op: "shout"}},
+		{name: "unknown case", step: config.TransformStep{Op: "case", Case: "camel"}},
+		{name: "bad pattern", step: config.TransformStep{Op: "replace", Pattern: "("}},
+		{name: "bad template", step: config.TransformStep{Op: "template", Value: "{{.name"}},
+		{name: "lookup without source", step: config.TransformStep{Op: "lookup"}},
+		{name: "lookup with two sources", step: config.TransformStep{Op: "lookup", Table: map[string]string{}, Sheet: "Regions"}},
+		{name: "unknown unmapped", step: config.TransformStep{Op: "lookup", Table: map[string]string{}, Unmapped: "drop"}},
+		{name: "missing csv", step: config.TransformStep{Op: "lookup", File: filepath.Join(t.TempDir(), "none.csv")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newStep(tt.step, map[string]*Table{}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLookupSheetNotLoaded(t *testing.T) {
+	step, err := newStep(config.TransformStep{Op: "lookup", Sheet: "Regions"}, map[string]*Table{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := step("N", nil); err == nil {
+		t.Error("expected an error for a sheet that was never filled")
+	}
+}
+
+func TestPipelineOrder(t *testing.T) {
+	rules := []config.FieldTransform{
+		{Field: "region", Steps: []config.TransformStep{
+			{Op: "trim"},
+			{Op: "case", Case: "upper"},
+			{Op: "lookup", Table: map[string]string{"N": "North"}, Unmapped: UnmappedDefault, Value: "unknown"},
+			{Op: "default", Value: "none"},
+		}},
+		// Runs after region, so it sees the translated value
+		{Field: "label", Steps: []config.TransformStep{
+			{Op: "template", Value: "{{.name}} - {{.region}}"},
+		}},
+	}
+	p, err := newPipeline(rules, map[string]*Table{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		row  map[string]string
+		want map[string]string
+	}{
+		{
+			row:  map[string]string{"name": "Acme", "region": " n "},
+			want: map[string]string{"name": "Acme", "region": "North", "label": "Acme - North"},
+		},
+		{
+			row:  map[string]string{"name": "Beta", "region": "x"},
+			want: map[string]string{"name": "Beta", "region": "unknown", "label": "Beta - unknown"},
+		},
+	}
+	for _, tt := range tests {
+		reject, errs := p.Apply(tt.row)
+		if reject != "" || len(errs) > 0 {
+			t.Fatalf("Apply: %q, %v", reject, errs)
+		}
+		if !reflect.DeepEqual(tt.row, tt.want) {
+			t.Errorf("got %v, want %v", tt.row, tt.want)
+		}
+	}
+}
+
+func TestPipelineReject(t *testing.T) {
+	rules := []config.FieldTransform{
+		{Field: "region", Steps: []config.TransformStep{
+			{Op: "lookup", Table: map[string]string{"N": "North"}, Unmapped: UnmappedReject},
+			{Op: "case", Case: "upper"},
+		}},
+		{Field: "name", Steps: []config.TransformStep{{Op: "case", Case: "upper"}}},
+	}
+	p, err := newPipeline(rules, map[string]*Table{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := map[string]string{"name": "acme", "region": "W"}
+	reject, _ := p.Apply(row)
+	if reject == "" {
+		t.Fatal("expected the row to be rejected")
+	}
+	// Later steps and rules do not run once a row is rejected
+	if row["region"] != "W" || row["name"] != "acme" {
+		t.Errorf("row changed after rejection: %v", row)
+	}
+}
+
+func TestPipelineAttributeField(t *testing.T) {
+	p, err := newPipeline([]config.FieldTransform{
+		{Field: "segment", Steps: []config.TransformStep{{Op: "case", Case: "lower"}}},
+	}, map[string]*Table{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := map[string]string{"Segment": "RETAIL"}
+	p.Apply(row)
+	if row["Segment"] != "retail" {
+		t.Errorf("got %v, want the Segment header transformed", row)
+	}
+}
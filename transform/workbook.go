@@ -0,0 +1,185 @@
+package transform
+
+import (
+	"fmt"
+	"sort"
+
+	"importer/config"
+	"importer/models"
+)
+
+// Rules are the compiled pipelines of the three sheets of a workbook
+type Rules struct {
+	customers *Pipeline
+	accounts  *Pipeline
+	links     *Pipeline
+
+	sheets map[string]*Table // Lookup tables read from the imported workbook
+}
+
+// Reject is a row rejected by a lookup
+type Reject struct {
+	Sheet  string
+	Row    int
+	Reason string
+}
+
+// New compiles the configured rules, reading lookup tables from CSV files
+func New(cfg config.TransformsConfig) (*Rules, error) {
+	r := &Rules{sheets: make(map[string]*Table)}
+	var err error
+	if r.customers, err = newPipeline(cfg.Customers, r.sheets); err != nil {
+		return nil, fmt.Errorf("customer transforms: %v", err)
+	}
+	if r.accounts, err = newPipeline(cfg.Accounts, r.sheets); err != nil {
+		return nil, fmt.Errorf("account transforms: %v", err)
+	}
+	if r.links, err = newPipeline(cfg.Links, r.sheets); err != nil {
+		return nil, fmt.Errorf("link transforms: %v", err)
+	}
+	return r, nil
+}
+
+// Sheets returns the sheets of the imported workbook holding lookup tables
+func (r *Rules) Sheets() []string {
+	names := make([]string, 0, len(r.sheets))
+	for name := range r.sheets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FillSheet sets the lookup table read from a sheet of the imported workbook
+func (r *Rules) FillSheet(sheet string, rows [][]string) error {
+	t, ok := r.sheets[sheet]
+	if !ok {
+		return nil
+	}
+	return t.Fill(rows)
+}
+
+// Apply transforms every row of the workbook in place. Fields are named as
+// in the target (customer_number, email, ...); other names refer to
+// attributes by header. Rows rejected by a lookup are removed from the
+// workbook and returned.
+func (r *Rules) Apply(wb *models.Workbook) ([]Reject, models.ValidationErrors) {
+	var rejects []Reject
+	var errs models.ValidationErrors
+	// apply reports whether the row is kept
+	apply := func(p *Pipeline, sheet string, rowNum int, row map[string]string) bool {
+		reason, fieldErrs := p.Apply(row)
+		for _, e := range fieldErrs {
+			errs = append(errs, models.RowError{Sheet: sheet, Row: rowNum, ValidationError: e})
+		}
+		if reason != "" {
+			rejects = append(rejects, Reject{Sheet: sheet, Row: rowNum, Reason: reason})
+			return false
+		}
+		return true
+	}
+
+	if len(r.customers.rules) > 0 {
+		kept := wb.Customers[:0]
+		for _, c := range wb.Customers {
+			row := withAttributes(c.Attributes, map[string]string{
+				"client_id":       c.ClientID,
+				"customer_number": c.CustomerNumber,
+				"customer_name":   c.CustomerName,
+				"address":         c.Address,
+				"name":            c.Name,
+				"email":           c.Email,
+			})
+			if !apply(r.customers, models.CustomersSheet, c.Row, row) {
+				continue
+			}
+			c.ClientID = take(row, "client_id")
+			c.CustomerNumber = take(row, "customer_number")
+			c.CustomerName = take(row, "customer_name")
+			c.Address = take(row, "address")
+			c.Name = take(row, "name")
+			c.Email = take(row, "email")
+			c.Attributes = attributesOf(row)
+			kept = append(kept, c)
+		}
+		wb.Customers = kept
+	}
+
+	if len(r.accounts.rules) > 0 {
+		kept := wb.Accounts[:0]
+		for _, a := range wb.Accounts {
+			row := withAttributes(a.Attributes, map[string]string{
+				"client_id":      a.ClientID,
+				"account_number": a.AccountNumber,
+				"account_name":   a.AccountName,
+			})
+			if !apply(r.accounts, models.AccountsSheet, a.Row, row) {
+				continue
+			}
+			a.ClientID = take(row, "client_id")
+			a.AccountNumber = take(row, "account_number")
+			a.AccountName = take(row, "account_name")
+			a.Attributes = attributesOf(row)
+			kept = append(kept, a)
+		}
+		wb.Accounts = kept
+	}
+
+	if len(r.links.rules) > 0 {
+		kept := wb.Links[:0]
+		for _, l := range wb.Links {
+			row := withAttributes(l.Attributes, map[string]string{
+				"client_id":       l.ClientID,
+				"customer_number": l.CustomerNumber,
+				"account_number":  l.AccountNumber,
+			})
+			if !apply(r.links, models.LinksSheet, l.Row, row) {
+				continue
+			}
+			l.ClientID = take(row, "client_id")
+			l.CustomerNumber = take(row, "customer_number")
+			l.AccountNumber = take(row, "account_number")
+			l.Attributes = attributesOf(row)
+			kept = append(kept, l)
+		}
+		wb.Links = kept
+	}
+
+	return rejects, errs
+}
+
+// withAttributes adds attributes to the fields of a row, fields taking
+// precedence over attributes with the same header
+func withAttributes(attrs, fields map[string]string) map[string]string {
+	row := make(map[string]string, len(attrs)+len(fields))
+	for name, value := range attrs {
+		row[name] = value
+	}
+	for name, value := range fields {
+		row[name] = value
+	}
+	return row
+}
+
+// take removes a field from the row, returning its value
+func take(row map[string]string, field string) string {
+	value := row[field]
+	delete(row, field)
+	return value
+}
+
+// attributesOf returns what is left of a row once its fields are taken, as
+// attributes; empty values are dropped like empty cells
+func attributesOf(row map[string]string) map[string]string {
+	var attrs map[string]string
+	for name, value := range row {
+		if value == "" {
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		attrs[name] = value
+	}
+	return attrs
+}